@@ -2,119 +2,212 @@
 package ac3
 
 import (
+	"context"
 	"fmt"
 	"github.com/hranicka/mediatool/internal"
 	"log/slog"
-	"os"
-	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
-	"strings"
 )
 
 var (
 	commentRegExp = regexp.MustCompile(`(?i)(?:comment|director)`)
 )
 
-func Process(src string, lang string, minBitRate int, dryRun bool, del bool) error {
+// Processor converts DTS, TrueHD, FLAC or E-AC3 audio streams to AC3 in place.
+type Processor struct {
+	Exec internal.Executor
+	// Lang is a language which must be among the converted streams to trigger
+	// the conversion, empty means any language.
+	Lang string
+	// MinBitRate is the lowest bitrate of an existing AC3 stream to be
+	// considered as already converted.
+	MinBitRate int
+	// Codec is the target audio codec, AC3 when empty.
+	Codec string
+	// BitRate is the target bitrate passed to ffmpeg, 640k when empty.
+	BitRate string
+	// Channels downmixes converted streams to the number of channels, zero
+	// keeps the source layout.
+	Channels int
+	// MinChannels is the lowest channel count of an existing stream of unknown
+	// bitrate to be considered as already converted. Zero means Channels when
+	// downmixing, so downmixed streams are not converted again, 6 otherwise.
+	MinChannels int
+	// Extensions are the accepted file extensions, internal.MediaExtensions
+	// are used when empty.
+	Extensions []string
+	DryRun     bool
+	Del        bool
+}
+
+func (p *Processor) Process(ctx context.Context, src string) error {
 	// read file streams
-	slog.Debug("opening file", "path", src)
+	slog.DebugContext(ctx, "opening file", "path", src)
 
 	// check file format
-	extension := strings.ToLower(filepath.Ext(src))
-	if extension != ".mkv" && extension != ".mp4" {
-		return fmt.Errorf("unsupported file format: %s", src)
+	if err := internal.CheckExtension(src, p.Extensions); err != nil {
+		return err
 	}
 
 	// probe file
-	f, err := internal.Probe(src)
+	f, err := internal.Probe(ctx, p.Exec, src)
 	if err != nil {
 		return fmt.Errorf("cannot get file info: %v", err)
 	}
 
-	// add type-specific stream counters
-	cnt := make(map[string]int)
-	for i, s := range f.Streams {
-		if _, ok := cnt[s.CodecType]; ok {
-			cnt[s.CodecType]++
-		} else {
-			cnt[s.CodecType] = 0
+	toConvert, err := p.Detect(ctx, src, f)
+	if err != nil {
+		return err
+	}
+	if len(toConvert) > 0 {
+		slog.InfoContext(ctx, "converting tracks", "file", src, "cnt", len(toConvert), "streams", toConvert)
+
+		err := internal.Execute(ctx, p.Exec, &internal.PlanEntry{
+			File:     src,
+			Streams:  toConvert,
+			Class:    internal.Expensive,
+			Duration: f.Format.Duration,
+			Args:     p.args(src, toConvert),
+			Output:   internal.ExpectStreams(f, true, nil, internal.StreamCodecs(toConvert, p.TargetCodec())),
+			Estimate: internal.EstimateOutputSize(f, toConvert, p.Bitrates(toConvert)),
+		}, p.DryRun, p.Del)
+		if err != nil {
+			return err
 		}
-		f.Streams[i].TypeIndex = cnt[s.CodecType]
 	}
 
+	slog.DebugContext(ctx, "file finished", "file", src)
+	return nil
+}
+
+// Detect returns audio streams of the probed file which should be converted,
+// or nil when the file needs no conversion.
+func (p *Processor) Detect(ctx context.Context, src string, f *internal.FFprobe) ([]internal.Stream, error) {
+	f.IndexStreams()
+
 	// detect streams for conversion
 	valid := make(map[string]internal.Stream)
 	bad := make(map[string]internal.Stream)
+	fallback := make(map[string]internal.Stream)
 	for _, s := range f.Streams {
 		if s.CodecType != internal.TypeAudio {
 			continue
 		}
 
 		switch s.CodecName {
-		case internal.CodecAC3:
+		case p.TargetCodec():
 			// exclude commentary and low bitrate tracks
 			bitRate, _ := strconv.Atoi(s.BitRate)
-			if commentRegExp.MatchString(s.Tags.Title) || (bitRate > 0 && bitRate < minBitRate) || (bitRate == 0 && s.Channels < 6) {
-				slog.Debug("low bitrate or commentary stream, skipping", "file", src, "stream", s)
+			if commentRegExp.MatchString(s.Tags.Title) || (bitRate > 0 && bitRate < p.MinBitRate) || (bitRate == 0 && s.Channels < p.minChannels()) {
+				slog.DebugContext(ctx, "low bitrate or commentary stream, skipping", "file", src, "stream", s)
 				break
 			}
 			valid[s.Tags.Language] = s
 		case internal.CodecDTS, internal.CodecTrueHD, internal.CodecFLAC, internal.CodecEAC3:
 			bad[s.Tags.Language] = s
+		case internal.CodecAC3:
+			// AC3 is converted only if the target codec differs and there is
+			// no better source of the language
+			if !commentRegExp.MatchString(s.Tags.Title) {
+				fallback[s.Tags.Language] = s
+			}
+		}
+	}
+	for l, s := range fallback {
+		if _, ok := bad[l]; !ok {
+			bad[l] = s
 		}
 	}
 
-	hasLang := lang == ""
+	hasLang := p.Lang == ""
 	var toConvert []internal.Stream
 	for l, bs := range bad {
 		if _, ok := valid[l]; ok {
-			slog.Debug("already converted stream, skipping", "file", src, "stream", l)
+			slog.DebugContext(ctx, "already converted stream, skipping", "file", src, "stream", l)
 			continue
 		}
 
-		if l == lang {
+		if l == p.Lang {
 			hasLang = true
 		}
 		toConvert = append(toConvert, bs)
-		slog.Debug("stream for conversion found (codec %s)", "file", src, "stream", l, "codec", bs.CodecName)
+		slog.DebugContext(ctx, "stream for conversion found (codec %s)", "file", src, "stream", l, "codec", bs.CodecName)
 	}
 
+	sort.Slice(toConvert, func(i, j int) bool {
+		return toConvert[i].Index < toConvert[j].Index
+	})
+
 	// convert if needed
 	if len(toConvert) == 0 {
-		slog.Debug("no conversion needed, nothing to convert", "file", src)
+		slog.DebugContext(ctx, "no conversion needed, nothing to convert", "file", src)
+		internal.ReportDecision(ctx, internal.DecisionSkip, "nothing to convert", nil)
+		return nil, nil
 	} else if !hasLang {
-		slog.Debug("no conversion needed, does not contain language", "file", src, "lang", lang)
-	} else {
-		slog.Info("converting tracks", "file", src, "cnt", len(toConvert), "streams", toConvert)
-
-		if !dryRun {
-			dst := src + ".tmp" + extension
-			if err := convert(src, dst, toConvert); err != nil {
-				return fmt.Errorf("cannot convert file: %v", err)
-			}
+		slog.DebugContext(ctx, "no conversion needed, does not contain language", "file", src, "lang", p.Lang)
+		internal.ReportDecision(ctx, internal.DecisionSkip, fmt.Sprintf("does not contain language %s", p.Lang), nil)
+		return nil, nil
+	}
+	internal.ReportDecision(ctx, internal.DecisionProcess, fmt.Sprintf("audio streams without %s", p.TargetCodec()), toConvert)
+	return toConvert, nil
+}
 
-			old := src + ".old"
-			if err := os.Rename(src, old); err != nil {
-				return fmt.Errorf("cannot rename source file: %v", err)
-			}
-			if err := os.Rename(dst, src); err != nil {
-				return fmt.Errorf("cannot rename converted file: %v", err)
-			}
+// TargetCodec returns the codec of converted streams.
+func (p *Processor) TargetCodec() string {
+	if p.Codec == "" {
+		return internal.CodecAC3
+	}
+	return p.Codec
+}
 
-			if del {
-				if err := os.Remove(old); err != nil {
-					return fmt.Errorf("cannot delete source file: %v", err)
-				}
-			}
-		}
+func (p *Processor) bitRate() string {
+	if p.BitRate == "" {
+		return "640k"
 	}
+	return p.BitRate
+}
 
-	slog.Debug("file finished", "file", src)
-	return nil
+// Bitrates returns output bitrates of the converted streams in bits per second
+// for EstimateOutputSize.
+func (p *Processor) Bitrates(streams []internal.Stream) map[int]int64 {
+	bitrates := make(map[int]int64, len(streams))
+	br, err := internal.ParseBitRate(p.bitRate())
+	if err != nil {
+		return bitrates
+	}
+	for _, s := range streams {
+		bitrates[s.Index] = br
+	}
+	return bitrates
 }
 
-func convert(src string, dst string, streams []internal.Stream) error {
+// minChannels returns the lowest channel count of a stream of unknown bitrate
+// to be considered as already converted.
+func (p *Processor) minChannels() int {
+	if p.MinChannels > 0 {
+		return p.MinChannels
+	}
+	if p.Channels > 0 {
+		return p.Channels
+	}
+	return 6
+}
+
+// StreamArgs returns ffmpeg arguments converting the output audio stream with
+// the given index.
+func (p *Processor) StreamArgs(index int) []string {
+	args := []string{fmt.Sprintf("-c:a:%d", index), p.TargetCodec(), fmt.Sprintf("-b:a:%d", index), p.bitRate()}
+	if p.Channels > 0 {
+		args = append(args, fmt.Sprintf("-ac:a:%d", index), strconv.Itoa(p.Channels))
+	}
+	return args
+}
+
+// args returns ffmpeg arguments converting the streams in place, other streams
+// are copied.
+func (p *Processor) args(src string, streams []internal.Stream) []string {
 	var args []string
 	args = append(args, "-i", src)
 	args = append(args, "-map", "0")
@@ -122,15 +215,11 @@ func convert(src string, dst string, streams []internal.Stream) error {
 	args = append(args, "-c:a", "copy")
 
 	for _, s := range streams {
-		args = append(args, fmt.Sprintf("-c:a:%d", s.TypeIndex), "ac3", fmt.Sprintf("-b:a:%d", s.TypeIndex), "640k")
+		args = append(args, p.StreamArgs(s.TypeIndex)...)
 	}
 
 	args = append(args, "-c:s", "copy")
 	args = append(args, "-max_muxing_queue_size", "4096")
-	args = append(args, dst)
-
-	slog.Debug("running ffmpeg", "file", src, "cmd", fmt.Sprintf("%s %v\n", internal.FFmpegPath, strings.Join(args, " ")))
-
-	_, err := internal.RunCmd(internal.FFmpegPath, args...)
-	return err
+	args = append(args, internal.TempPath(src))
+	return args
 }
@@ -0,0 +1,107 @@
+package ac3
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/hranicka/mediatool/internal"
+	"github.com/hranicka/mediatool/internal/exectest"
+)
+
+func TestProcessGolden(t *testing.T) {
+	for _, fixture := range exectest.Fixtures(t, "../testdata/ffprobe/*.json") {
+		name := strings.TrimSuffix(filepath.Base(fixture), ".json")
+		t.Run(name, func(t *testing.T) {
+			rec, dir := exectest.ProcessFixture(t, fixture, func(e *exectest.Recorder, src string) error {
+				p := &Processor{Exec: e, MinBitRate: 448000}
+				return p.Process(context.Background(), src)
+			})
+			got := exectest.Format(rec.Calls(internal.FFmpegPath), dir)
+			exectest.Golden(t, filepath.Join("testdata", name+".golden"), got)
+		})
+	}
+}
+
+func TestProcessMissingLang(t *testing.T) {
+	rec, _ := exectest.ProcessFixture(t, "../testdata/ffprobe/anime_eac3.json", func(e *exectest.Recorder, src string) error {
+		p := &Processor{Exec: e, Lang: "cze", MinBitRate: 448000}
+		return p.Process(context.Background(), src)
+	})
+	if calls := rec.Calls(internal.FFmpegPath); len(calls) != 0 {
+		t.Errorf("expected no conversion, got %v", calls)
+	}
+}
+
+func TestProcessStereoAAC(t *testing.T) {
+	rec, _ := exectest.ProcessFixture(t, "../testdata/ffprobe/movie_hevc_multilang.json", func(e *exectest.Recorder, src string) error {
+		p := &Processor{Exec: e, MinBitRate: 128000, Codec: internal.CodecAAC, BitRate: "192k", Channels: 2}
+		return p.Process(context.Background(), src)
+	})
+
+	calls := rec.Calls(internal.FFmpegPath)
+	if len(calls) != 1 {
+		t.Fatalf("expected single conversion, got %v", calls)
+	}
+	args := strings.Join(calls[0].Args, " ")
+	for _, want := range []string{"-c:a:0 aac -b:a:0 192k -ac:a:0 2", "-c:a:2 aac -b:a:2 192k -ac:a:2 2"} {
+		if !strings.Contains(args, want) {
+			t.Errorf("expected %q in %s", want, args)
+		}
+	}
+}
+
+func TestDetectMinChannels(t *testing.T) {
+	probe := func() *internal.FFprobe {
+		return &internal.FFprobe{Streams: []internal.Stream{
+			{Index: 0, CodecType: internal.TypeAudio, CodecName: internal.CodecDTS, Channels: 6, Tags: internal.Tags{Language: "eng"}},
+			{Index: 1, CodecType: internal.TypeAudio, CodecName: internal.CodecAC3, Channels: 2, Tags: internal.Tags{Language: "eng"}},
+		}}
+	}
+	tests := []struct {
+		p    Processor
+		want int
+	}{
+		// a stereo AC3 track counts as converted only when downmixing to stereo
+		{Processor{}, 1},
+		{Processor{Channels: 2}, 0},
+		{Processor{Channels: 2, MinChannels: 6}, 1},
+	}
+	for _, tt := range tests {
+		got, err := tt.p.Detect(context.Background(), "a.mkv", probe())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != tt.want {
+			t.Errorf("channels %d, min %d: got %d streams, want %d", tt.p.Channels, tt.p.MinChannels, len(got), tt.want)
+		}
+	}
+}
+
+func TestProcessMP4(t *testing.T) {
+	var src string
+	rec, dir := exectest.ProcessFixtureAs(t, "../testdata/ffprobe/mp4/movie_h264_dts.json", ".mp4", func(e *exectest.Recorder, path string) error {
+		src = path
+		p := &Processor{Exec: e, MinBitRate: 448000}
+		return p.Process(context.Background(), path)
+	})
+
+	// streams copied from an MP4 fit into it, so the file is not remuxed
+	calls := rec.Calls(internal.FFmpegPath)
+	if len(calls) != 1 {
+		t.Fatalf("expected single conversion, got %v", calls)
+	}
+	args := calls[0].Args
+	if args[len(args)-1] != internal.TempPath(src) || slices.Contains(args, "-f") {
+		t.Errorf("unexpected output: %s", exectest.Format(calls, dir))
+	}
+	if _, err := os.Stat(src); err != nil {
+		t.Errorf("converted file not in place: %v", err)
+	}
+	if _, err := os.Stat(strings.TrimSuffix(src, ".mp4") + ".mkv"); err == nil {
+		t.Error("file remuxed into MKV")
+	}
+}
@@ -0,0 +1,288 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// BackupDir is a directory originals are moved to after conversion instead
+	// of being kept next to the converted files. Empty keeps them as
+	// OldPath(src).
+	BackupDir string
+
+	backupMu sync.Mutex
+)
+
+// BackupManifestName is the name of the manifest in the backup directory.
+const BackupManifestName = "manifest.jsonl"
+
+// BackupEntry is a record of a single original in the backup manifest.
+type BackupEntry struct {
+	Original string    `json:"original"`
+	Backup   string    `json:"backup"`
+	Size     int64     `json:"size"`
+	Time     time.Time `json:"time"`
+}
+
+// backupOriginal moves the original old of the converted file src into the
+// backup directory, mirroring its absolute path, and records it in the
+// manifest.
+func backupOriginal(dir string, src string, old string) error {
+	abs, err := filepath.Abs(src)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(old)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("cannot create backup directory: %v", err)
+	}
+	unlock, err := lockBackupManifest(dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// an earlier original of the same file is kept as well
+	dst := filepath.Join(dir, abs)
+	for i := 1; exists(dst); i++ {
+		dst = filepath.Join(dir, abs) + "." + strconv.Itoa(i)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return fmt.Errorf("cannot create backup directory: %v", err)
+	}
+
+	// recorded first, so a crash cannot leave a backup which is never purged,
+	// records of backups which were not moved are dropped by PurgeBackups
+	e := BackupEntry{Original: abs, Backup: dst, Size: info.Size(), Time: time.Now()}
+	if err := appendBackupEntry(dir, e); err != nil {
+		return fmt.Errorf("cannot write backup manifest: %v", err)
+	}
+	if err := moveFile(old, dst); err != nil {
+		return fmt.Errorf("cannot move original to backup: %v", err)
+	}
+	slog.Debug("original moved to backup", "file", src, "backup", dst)
+	return nil
+}
+
+// lockBackupManifest guards the manifest in dir against concurrent updates by
+// this and other processes, e.g. purge running along a batch. The manifest is
+// replaced on purge, so a separate lock file is locked.
+func lockBackupManifest(dir string) (unlock func(), err error) {
+	backupMu.Lock()
+	unlockFile, err := lockFile(filepath.Join(dir, BackupManifestName+".lock"))
+	if err != nil {
+		backupMu.Unlock()
+		return nil, fmt.Errorf("cannot lock backup manifest: %v", err)
+	}
+	return func() {
+		unlockFile()
+		backupMu.Unlock()
+	}, nil
+}
+
+// inBackupDir reports whether path is BackupDir or within it, so backups are
+// not processed again when the directory is in the library.
+func inBackupDir(path string) bool {
+	if BackupDir == "" {
+		return false
+	}
+	dir, err := filepath.Abs(BackupDir)
+	if err != nil {
+		return false
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	_, within := relPath(dir, abs)
+	return within || abs == dir
+}
+
+func appendBackupEntry(dir string, e BackupEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, BackupManifestName), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadBackupManifest returns entries of the backup manifest in dir, oldest
+// first. A missing manifest means there are no backups.
+func ReadBackupManifest(dir string) ([]BackupEntry, error) {
+	f, err := os.Open(filepath.Join(dir, BackupManifestName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []BackupEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e BackupEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			slog.Warn("skipping invalid backup manifest record", "dir", dir, "error", err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+	return entries, nil
+}
+
+// PurgeBackups removes backups older than maxAge and then the oldest ones
+// until the total size is at most maxSize. Zero limits are not applied.
+// Entries of backups removed by other means are dropped from the manifest too.
+// With dryRun set, the expired entries are only returned.
+func PurgeBackups(dir string, maxAge time.Duration, maxSize int64, dryRun bool) (expired []BackupEntry, err error) {
+	unlock, err := lockBackupManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	entries, err := ReadBackupManifest(dir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read backup manifest: %v", err)
+	}
+
+	var kept []BackupEntry
+	var total int64
+	for _, e := range entries {
+		switch {
+		case !exists(e.Backup):
+			slog.Debug("backup missing, dropping it from manifest", "backup", e.Backup)
+		case maxAge > 0 && time.Since(e.Time) > maxAge:
+			expired = append(expired, e)
+		default:
+			kept = append(kept, e)
+			total += e.Size
+		}
+	}
+	for maxSize > 0 && total > maxSize && len(kept) > 0 {
+		expired = append(expired, kept[0])
+		total -= kept[0].Size
+		kept = kept[1:]
+	}
+
+	if dryRun {
+		return expired, nil
+	}
+
+	for _, e := range expired {
+		if err := os.Remove(e.Backup); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("cannot remove backup: %v", err)
+		}
+		removeEmptyDirs(filepath.Dir(e.Backup), dir)
+	}
+	if err := writeBackupManifest(dir, kept); err != nil {
+		return nil, fmt.Errorf("cannot write backup manifest: %v", err)
+	}
+	return expired, nil
+}
+
+func writeBackupManifest(dir string, entries []BackupEntry) error {
+	path := filepath.Join(dir, BackupManifestName)
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// removeEmptyDirs removes dir and its parents up to root while they are empty.
+func removeEmptyDirs(dir string, root string) {
+	root = filepath.Clean(root)
+	for dir = filepath.Clean(dir); dir != root && len(dir) > len(root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			return
+		}
+	}
+}
+
+// moveFile renames src to dst, copying the file when they are on different
+// file systems.
+func moveFile(src string, dst string) error {
+	err := os.Rename(src, dst)
+	if err == nil {
+		return nil
+	}
+
+	if copyErr := copyFile(src, dst); copyErr != nil {
+		_ = os.Remove(dst)
+		return fmt.Errorf("%v, copy: %v", err, copyErr)
+	}
+	return os.Remove(src)
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, accessTime(info), info.ModTime())
+}
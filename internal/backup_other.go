@@ -0,0 +1,9 @@
+//go:build !unix
+
+package internal
+
+// lockFile does not lock anything, file locks are not supported, so only
+// backupMu guards the manifest within a process.
+func lockFile(_ string) (unlock func(), err error) {
+	return func() {}, nil
+}
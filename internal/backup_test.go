@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestSwapBackup(t *testing.T) {
+	dir := t.TempDir()
+	defer func(dir string) { BackupDir = dir }(BackupDir)
+	BackupDir = filepath.Join(dir, "backup")
+
+	src := filepath.Join(dir, "library", "movie.mkv")
+	if err := os.MkdirAll(filepath.Dir(src), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 2 {
+		for path, content := range map[string]string{src: "src", TempPath(src): "tmp"} {
+			if err := os.WriteFile(path, []byte(content+string(rune('0'+i))), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := swap(context.Background(), src, src, TempPath(src), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if exists(OldPath(src)) {
+		t.Error("original kept next to the converted file")
+	}
+	entries, err := ReadBackupManifest(BackupDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Backup != filepath.Join(BackupDir, src) || entries[1].Backup != filepath.Join(BackupDir, src)+".1" {
+		t.Fatalf("unexpected manifest: %+v", entries)
+	}
+	for i, e := range entries {
+		if data, _ := os.ReadFile(e.Backup); string(data) != "src"+string(rune('0'+i)) {
+			t.Errorf("unexpected backup content: %s", data)
+		}
+	}
+}
+
+func TestPurgeBackups(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	var entries []BackupEntry
+	for i, age := range []time.Duration{72 * time.Hour, 48 * time.Hour, 24 * time.Hour, time.Hour} {
+		path := filepath.Join(dir, "library", string(rune('a'+i))+".mkv")
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, 100), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, BackupEntry{Original: path, Backup: path, Size: 100, Time: now.Add(-age)})
+	}
+	if err := writeBackupManifest(dir, entries); err != nil {
+		t.Fatal(err)
+	}
+
+	expired, err := PurgeBackups(dir, 60*time.Hour, 150, true)
+	if err != nil || len(expired) != 3 || !exists(entries[0].Backup) {
+		t.Fatalf("unexpected dry run: %+v, %v", expired, err)
+	}
+
+	if _, err := PurgeBackups(dir, 60*time.Hour, 150, false); err != nil {
+		t.Fatal(err)
+	}
+	kept, err := ReadBackupManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kept) != 1 || kept[0].Backup != entries[3].Backup {
+		t.Fatalf("unexpected kept backups: %+v", kept)
+	}
+	for _, e := range entries[:3] {
+		if exists(e.Backup) {
+			t.Errorf("backup %s not removed", e.Backup)
+		}
+	}
+}
+
+func TestBackupManifestLock(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file locks not supported")
+	}
+	dir := t.TempDir()
+	old := filepath.Join(dir, "movie.mkv.old")
+	if err := os.WriteFile(old, []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// another process holding the lock, e.g. purge, delays the backup
+	unlock, err := lockFile(filepath.Join(dir, BackupManifestName+".lock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- backupOriginal(dir, filepath.Join(dir, "movie.mkv"), old)
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("backup not delayed by the lock: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlock()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := ReadBackupManifest(dir); err != nil || len(entries) != 1 {
+		t.Errorf("unexpected manifest: %+v, %v", entries, err)
+	}
+}
@@ -0,0 +1,218 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var (
+	probeCache *ProbeCache
+)
+
+// ProbeCache is a persistent store of ffprobe results. Entries are keyed by
+// absolute file path and are valid only while the file size and modification
+// time stay the same.
+type ProbeCache struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string]probeCacheEntry
+	dirty   bool
+
+	hits          int
+	misses        int
+	invalidations int
+}
+
+type probeCacheEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Probe   *FFprobe  `json:"probe"`
+}
+
+// DefaultProbeCachePath returns the cache file location in the user cache
+// directory ($XDG_CACHE_HOME on Linux), or an empty string if it is unknown.
+func DefaultProbeCachePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "mediatool", "probe-cache.json")
+}
+
+// OpenProbeCache loads the cache from path. A missing file results in an empty
+// cache, an unreadable one is reported and replaced on Close.
+func OpenProbeCache(path string) (*ProbeCache, error) {
+	if path == "" {
+		return nil, errors.New("cache path not set")
+	}
+
+	c := &ProbeCache{path: path, entries: make(map[string]probeCacheEntry)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read cache: %w", err)
+	}
+	if err := json.Unmarshal(data, &c.entries); err != nil {
+		slog.Warn("cannot parse probe cache, starting empty", "path", path, "error", err)
+		c.entries = make(map[string]probeCacheEntry)
+	}
+
+	slog.Debug("probe cache loaded", "path", path, "entries", len(c.entries))
+	return c, nil
+}
+
+// SetupProbeCache opens the cache at path and makes Probe use it. The returned
+// function saves the cache and must be called before the application exits.
+// Failures are logged only, the application works without the cache as well.
+func SetupProbeCache(path string, disabled bool) (closeCache func()) {
+	if disabled {
+		slog.Debug("probe cache disabled")
+		return func() {}
+	}
+
+	c, err := OpenProbeCache(path)
+	if err != nil {
+		slog.Warn("cannot open probe cache", "path", path, "error", err)
+		return func() {}
+	}
+
+	UseProbeCache(c)
+	return func() {
+		UseProbeCache(nil)
+		if err := c.Close(); err != nil {
+			slog.Warn("cannot save probe cache", "path", path, "error", err)
+		}
+	}
+}
+
+// UseProbeCache makes Probe use the cache, nil disables caching.
+func UseProbeCache(c *ProbeCache) {
+	probeCache = c
+}
+
+// InvalidateProbe drops the cached result of a file rewritten by a conversion.
+func InvalidateProbe(path string) {
+	if probeCache != nil {
+		probeCache.Invalidate(path)
+	}
+}
+
+func (c *ProbeCache) Get(path string, info os.FileInfo) (*FFprobe, bool) {
+	key := cacheKey(path)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if ok && e.Size == info.Size() && e.ModTime.Equal(info.ModTime()) && e.Probe != nil {
+		c.hits++
+		return e.Probe.clone(), true
+	}
+	if ok {
+		delete(c.entries, key)
+		c.dirty = true
+		c.invalidations++
+	}
+	c.misses++
+	return nil, false
+}
+
+func (c *ProbeCache) Put(path string, info os.FileInfo, f *FFprobe) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[cacheKey(path)] = probeCacheEntry{Size: info.Size(), ModTime: info.ModTime(), Probe: f.clone()}
+	c.dirty = true
+}
+
+func (c *ProbeCache) Invalidate(path string) {
+	key := cacheKey(path)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; ok {
+		delete(c.entries, key)
+		c.dirty = true
+		c.invalidations++
+	}
+}
+
+// Close writes the cache back to disk if it changed and logs its statistics.
+func (c *ProbeCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pruned := c.prune()
+	slog.Info("probe cache stats", "path", c.path, "entries", len(c.entries), "hits", c.hits, "misses", c.misses, "invalidations", c.invalidations, "pruned", pruned)
+	if !c.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		return fmt.Errorf("cannot encode cache: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return fmt.Errorf("cannot create cache directory: %w", err)
+	}
+
+	// write a unique temporary file first, so a crash never leaves a truncated
+	// cache and concurrent runs do not write into the same file
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), "."+filepath.Base(c.path)+".*")
+	if err != nil {
+		return fmt.Errorf("cannot write cache: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(0o644)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.path)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot write cache: %w", err)
+	}
+	c.dirty = false
+	return nil
+}
+
+// prune drops entries of deleted files and returns their number, must be
+// called with c.mu held. Files in missing directories are kept, they may be on
+// a disk which is not mounted.
+func (c *ProbeCache) prune() int {
+	pruned := 0
+	for path := range c.entries {
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if _, err := os.Stat(filepath.Dir(path)); err != nil {
+			continue
+		}
+		delete(c.entries, path)
+		c.dirty = true
+		pruned++
+	}
+	return pruned
+}
+
+func cacheKey(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProbeCache(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "movie.mkv")
+	if err := os.WriteFile(src, []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "cache", "probe.json")
+	c, err := OpenProbeCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Put(src, info, &FFprobe{Streams: []Stream{{Index: 0, CodecName: CodecH264}}})
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// reopened cache serves the stored result
+	c, err = OpenProbeCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, ok := c.Get(src, info)
+	if !ok || len(f.Streams) != 1 || f.Streams[0].CodecName != CodecH264 {
+		t.Fatalf("expected cache hit, got %v %v", f, ok)
+	}
+
+	// modified file is not served
+	mtime := info.ModTime().Add(time.Second)
+	if err := os.Chtimes(src, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if info, err = os.Stat(src); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get(src, info); ok {
+		t.Error("expected cache miss after modification")
+	}
+	if c.hits != 1 || c.misses != 1 || c.invalidations != 1 {
+		t.Errorf("unexpected stats: hits %d, misses %d, invalidations %d", c.hits, c.misses, c.invalidations)
+	}
+}
+
+func TestProbeCachePrune(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "movie.mkv")
+	if err := os.WriteFile(src, []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unmounted := filepath.Join(dir, "unmounted", "movie.mkv")
+
+	path := filepath.Join(dir, "probe.json")
+	c, err := OpenProbeCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Put(src, info, &FFprobe{})
+	c.Put(unmounted, info, &FFprobe{})
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// deleted files are dropped, files in missing directories are kept
+	if err := os.Remove(src); err != nil {
+		t.Fatal(err)
+	}
+	if c, err = OpenProbeCache(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if c, err = OpenProbeCache(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.entries[src]; ok {
+		t.Error("entry of deleted file kept")
+	}
+	if _, ok := c.entries[unmounted]; !ok {
+		t.Error("entry of file in missing directory dropped")
+	}
+
+	// no temporary files are left behind
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "probe.json" {
+		t.Errorf("unexpected files in cache directory: %v", entries)
+	}
+}
@@ -2,49 +2,85 @@
 package cleaner
 
 import (
+	"context"
 	"fmt"
 	"github.com/hranicka/mediatool/internal"
 	"log/slog"
-	"os"
-	"path/filepath"
 	"strings"
 )
 
 var (
-	whitelistLang = []string{
+	// DefaultLanguages is the default whitelist of stream languages.
+	DefaultLanguages = []string{
 		"", "und", "unknown",
 		"english", "eng", "en",
 		"czech", "cze", "ces", "cz", "cs",
 	}
 )
 
-func Process(src string, dryRun bool, del bool) error {
+// Processor removes streams in languages which are not whitelisted.
+type Processor struct {
+	Exec internal.Executor
+	// Languages is the whitelist of stream languages, DefaultLanguages are
+	// used when empty.
+	Languages []string
+	// Extensions are the accepted file extensions, internal.MediaExtensions
+	// are used when empty.
+	Extensions []string
+	DryRun     bool
+	Del        bool
+}
+
+func (p *Processor) Process(ctx context.Context, src string) error {
 	// read file streams
-	slog.Debug("opening file", "path", src)
+	slog.DebugContext(ctx, "opening file", "path", src)
 
 	// check file format
-	extension := strings.ToLower(filepath.Ext(src))
-	if extension != ".mkv" && extension != ".mp4" {
-		return fmt.Errorf("unsupported file format: %s", src)
+	if err := internal.CheckExtension(src, p.Extensions); err != nil {
+		return err
 	}
 
 	// probe file
-	f, err := internal.Probe(src)
+	f, err := internal.Probe(ctx, p.Exec, src)
 	if err != nil {
 		return fmt.Errorf("cannot get file info: %v", err)
 	}
 
-	// add type-specific stream counters
-	cnt := make(map[string]int)
-	for i, s := range f.Streams {
-		if _, ok := cnt[s.CodecType]; ok {
-			cnt[s.CodecType]++
-		} else {
-			cnt[s.CodecType] = 0
+	toRemove, err := p.Detect(ctx, src, f)
+	if err != nil {
+		return err
+	}
+	if len(toRemove) > 0 {
+		slog.InfoContext(ctx, "removing tracks", "file", src, "cnt", len(toRemove), "streams", toRemove)
+
+		args, err := p.args(src, toRemove)
+		if err != nil {
+			return err
+		}
+		err = internal.Execute(ctx, p.Exec, &internal.PlanEntry{
+			File:     src,
+			Streams:  toRemove,
+			Class:    internal.Cheap,
+			Duration: f.Format.Duration,
+			Args:     args,
+			Output:   internal.ExpectStreams(f, false, toRemove, nil),
+			Estimate: internal.EstimateOutputSize(f, toRemove, Bitrates(toRemove)),
+		}, p.DryRun, p.Del)
+		if err != nil {
+			return err
 		}
-		f.Streams[i].TypeIndex = cnt[s.CodecType]
 	}
 
+	slog.DebugContext(ctx, "file finished", "file", src)
+	return nil
+}
+
+// Detect returns streams of the probed file which should be removed, or nil
+// when the file needs no cleanup.
+func (p *Processor) Detect(ctx context.Context, src string, f *internal.FFprobe) ([]internal.Stream, error) {
+	// add type-specific stream counters
+	cnt := f.IndexStreams()
+
 	// detect streams for conversion
 	var toRemove []internal.Stream
 	for _, s := range f.Streams {
@@ -63,7 +99,7 @@ func Process(src string, dryRun bool, del bool) error {
 
 		// remove unwanted languages
 		var whitelisted bool
-		for _, lang := range whitelistLang {
+		for _, lang := range p.languages() {
 			if strings.EqualFold(s.Tags.Language, lang) {
 				whitelisted = true
 				break
@@ -75,43 +111,37 @@ func Process(src string, dryRun bool, del bool) error {
 		}
 	}
 
-	// convert if needed
 	if len(toRemove) == 0 {
-		slog.Debug("no cleanup needed", "file", src)
-	} else {
-		slog.Info("removing tracks", "file", src, "cnt", len(toRemove), "streams", toRemove)
-
-		if !dryRun {
-			dst := src + ".tmp" + extension
-			if err := cleanup(src, dst, toRemove); err != nil {
-				return fmt.Errorf("cannot convert file: %v", err)
-			}
-
-			old := src + ".old"
-			if err := os.Rename(src, old); err != nil {
-				return fmt.Errorf("cannot rename source file: %v", err)
-			}
-			if err := os.Rename(dst, src); err != nil {
-				return fmt.Errorf("cannot rename converted file: %v", err)
-			}
-
-			if del {
-				if err := os.Remove(old); err != nil {
-					return fmt.Errorf("cannot delete source file: %v", err)
-				}
-			}
-		}
+		slog.DebugContext(ctx, "no cleanup needed", "file", src)
+		internal.ReportDecision(ctx, internal.DecisionSkip, "no unwanted streams", nil)
+		return nil, nil
 	}
+	internal.ReportDecision(ctx, internal.DecisionProcess, "unwanted streams", toRemove)
+	return toRemove, nil
+}
 
-	slog.Debug("file finished", "file", src)
-	return nil
+func (p *Processor) languages() []string {
+	if len(p.Languages) == 0 {
+		return DefaultLanguages
+	}
+	return p.Languages
 }
 
-func cleanup(src string, dst string, streams []internal.Stream) error {
-	var args []string
-	args = append(args, "-i", src)
+// Bitrates returns zero bitrates of the removed streams for
+// EstimateOutputSize.
+func Bitrates(streams []internal.Stream) map[int]int64 {
+	bitrates := make(map[int]int64, len(streams))
+	for _, s := range streams {
+		bitrates[s.Index] = 0
+	}
+	return bitrates
+}
 
+// MapArgs returns ffmpeg arguments mapping video, audio and subtitles of the
+// input except the given streams.
+func MapArgs(streams []internal.Stream) ([]string, error) {
 	// preserve just video, audio and subtitles
+	var args []string
 	args = append(args, "-map", "0:v")
 	args = append(args, "-map", "0:a")
 	args = append(args, "-map", "0:s?")
@@ -126,19 +156,28 @@ func cleanup(src string, dst string, streams []internal.Stream) error {
 		case internal.TypeSubtitles:
 			t = "s"
 		default:
-			return fmt.Errorf("unsupported stream type: %s", s.CodecType)
+			return nil, fmt.Errorf("unsupported stream type: %s", s.CodecType)
 		}
 
 		args = append(args, "-map", fmt.Sprintf("-0:%s:%d", t, s.TypeIndex))
 	}
+	return args, nil
+}
+
+// args returns ffmpeg arguments removing the streams from the file.
+func (p *Processor) args(src string, streams []internal.Stream) ([]string, error) {
+	var args []string
+	args = append(args, "-i", src)
+
+	mapArgs, err := MapArgs(streams)
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, mapArgs...)
 
 	args = append(args, "-c", "copy")
 	args = append(args, "-map_metadata:g", "0:g") // remove additional metadata
 	args = append(args, "-max_muxing_queue_size", "4096")
-	args = append(args, dst)
-
-	slog.Debug("running ffmpeg", "file", src, "cmd", fmt.Sprintf("%s %v\n", internal.FFmpegPath, strings.Join(args, " ")))
-
-	_, err := internal.RunCmd(internal.FFmpegPath, args...)
-	return err
+	args = append(args, internal.TempPath(src))
+	return args, nil
 }
@@ -0,0 +1,25 @@
+package cleaner
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hranicka/mediatool/internal"
+	"github.com/hranicka/mediatool/internal/exectest"
+)
+
+func TestProcessGolden(t *testing.T) {
+	for _, fixture := range exectest.Fixtures(t, "../testdata/ffprobe/*.json") {
+		name := strings.TrimSuffix(filepath.Base(fixture), ".json")
+		t.Run(name, func(t *testing.T) {
+			rec, dir := exectest.ProcessFixture(t, fixture, func(e *exectest.Recorder, src string) error {
+				p := &Processor{Exec: e}
+				return p.Process(context.Background(), src)
+			})
+			got := exectest.Format(rec.Calls(internal.FFmpegPath), dir)
+			exectest.Golden(t, filepath.Join("testdata", name+".golden"), got)
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/hranicka/mediatool/internal"
+)
+
+// apply implements the apply command executing a plan written by -plan.
+func apply(args []string) int {
+	fs := flag.NewFlagSet("mediatool apply", flag.ContinueOnError)
+	var o options
+	fs.BoolVar(&o.verbose, "v", false, "verbose/debug output")
+	fs.StringVar(&internal.FFmpegPath, "ffmpeg", "ffmpeg", "ffmpeg path")
+	fs.BoolVar(&o.del, "del", false, "delete source files after successful conversion")
+	fs.BoolVar(&o.dryRun, "dry", false, "only check the plan can be applied")
+	swapFlags(fs)
+	fs.IntVar(&o.jobs, "jobs", 1, "number of files processed concurrently")
+	fs.IntVar(&o.probeJobs, "probe_jobs", 0, "max concurrent cheap operations like remuxing (0 = same as -jobs)")
+	fs.IntVar(&o.encodeJobs, "encode_jobs", 1, "max concurrent encodes (0 = same as -jobs)")
+	fs.StringVar(&o.reportJSON, "report_json", "", "write a JSON Lines report of processed files")
+	fs.StringVar(&o.reportCSV, "report_csv", "", "write a CSV report of processed files")
+	o.webhook.register(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: mediatool apply [flags] <plan>\n\nExecute conversions of a plan written by -plan. Files changed since\nplanning are refused.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := applyEnv(fs, "apply"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return exitUsage
+	}
+	if fs.NArg() != 1 || o.jobs < 1 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	internal.SetupLogging(o.verbose)
+	if o.dryRun {
+		slog.Info("DRY RUN")
+	}
+
+	entries, err := internal.ReadPlan(fs.Arg(0))
+	if err != nil {
+		slog.Error("cannot read plan", "error", err)
+		return exitFailure
+	}
+	planned := make(map[string]*internal.PlanEntry, len(entries))
+	files := make([]internal.File, 0, len(entries))
+	var missing []*internal.ReportEntry
+	for _, e := range entries {
+		info, err := os.Stat(e.File)
+		if err != nil {
+			// the rest of the plan is applied anyway
+			slog.Error("could not process", "file", e.File, "error", err)
+			missing = append(missing, &internal.ReportEntry{File: e.File, Command: "apply", Started: time.Now(), DryRun: o.dryRun, Error: err.Error()})
+			continue
+		}
+		planned[e.File] = e
+		files = append(files, internal.File{Path: e.File, Info: info})
+	}
+
+	internal.SetLimits(o.jobs, o.probeJobs, o.encodeJobs)
+
+	walkCtx, runCtx, stop := internal.NotifyContext(context.Background())
+	defer stop()
+
+	report, err := internal.OpenReport(o.reportJSON, o.reportCSV)
+	if err != nil {
+		slog.Error("cannot open report", "error", err)
+		return exitFailure
+	}
+
+	webhook, err := o.webhook.start()
+	if err != nil {
+		slog.Error("cannot load webhook template", "error", err)
+		return exitUsage
+	}
+	if webhook != nil {
+		defer webhook.Close()
+	}
+
+	runner := &internal.Runner{Jobs: o.jobs, Report: report, Command: "apply", DryRun: o.dryRun, Webhook: webhook}
+	summary := runner.Run(walkCtx, runCtx, files, func(ctx context.Context, path string) error {
+		e := planned[path]
+		if err := e.Verify(); err != nil {
+			return fmt.Errorf("refusing planned conversion: %v", err)
+		}
+		internal.ReportDecision(ctx, internal.DecisionProcess, "planned by "+e.Command, e.Streams)
+		slog.InfoContext(ctx, "applying plan", "file", path, "command", e.Command, "streams", e.Streams)
+		return internal.Execute(ctx, internal.CmdExecutor{}, e, o.dryRun, o.del)
+	})
+	summary.Total += len(missing)
+	summary.Failed += len(missing)
+	for _, e := range missing {
+		if report != nil {
+			if err := report.Write(e); err != nil {
+				slog.Error("could not write report", "file", e.File, "error", err)
+			}
+		}
+		if webhook != nil {
+			webhook.FileFinished(e)
+		}
+	}
+	if webhook != nil {
+		webhook.BatchFinished("apply", summary)
+	}
+
+	if report != nil {
+		if err := report.Close(); err != nil {
+			slog.Warn("cannot close report", "error", err)
+		}
+	}
+
+	if summary.Failed > 0 || !summary.Complete() {
+		return exitFailure
+	}
+	return 0
+}
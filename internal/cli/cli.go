@@ -0,0 +1,193 @@
+// Package cli implements the command line interface shared by mediatool and
+// the standalone converter binaries.
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/hranicka/mediatool/internal/config"
+)
+
+const (
+	// exitFailure is returned when some files could not be processed.
+	exitFailure = 1
+	// exitUsage is returned for invalid command line arguments.
+	exitUsage = 2
+
+	envPrefix = "MEDIATOOL_"
+)
+
+// command is a mediatool subcommand. Most commands process media files, the
+// others implement main instead of flags.
+type command struct {
+	name    string
+	summary string
+	// tool is the name of the former standalone binary, it names the ignore
+	// and journal files in the library.
+	tool string
+	// modify marks commands changing files, they support -del, -dry and
+	// -resume.
+	modify bool
+	// encode marks commands running encoders, they support -encode_jobs.
+	encode bool
+	// encodeJobs is the default of -encode_jobs.
+	encodeJobs int
+	// flags registers command specific flags and returns a constructor of the
+	// processor, which is called for every file with its effective config.
+	// Flags which override config settings are added to ov.
+	flags func(fs *flag.FlagSet, ov overrides) func(cfg config.Config, o *options) processor
+	// main runs a command not processing media files.
+	main func(args []string) int
+}
+
+// overrides maps names of flags to functions copying their values into the
+// config. Only flags given explicitly are applied.
+type overrides map[string]func(c *config.Config)
+
+// apply returns a function applying overrides of flags set in fs.
+func (ov overrides) apply(fs *flag.FlagSet) func(c *config.Config) {
+	var fns []func(c *config.Config)
+	fs.Visit(func(f *flag.Flag) {
+		if fn, ok := ov[f.Name]; ok {
+			fns = append(fns, fn)
+		}
+	})
+	return func(c *config.Config) {
+		for _, fn := range fns {
+			fn(c)
+		}
+	}
+}
+
+var commands []*command
+
+func register(c *command) {
+	commands = append(commands, c)
+}
+
+func lookup(name string) *command {
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// Main runs mediatool with the given arguments (without the program name) and
+// returns the exit code.
+func Main(args []string) int {
+	if len(args) == 0 {
+		usage(os.Stderr)
+		return exitUsage
+	}
+
+	switch name := args[0]; name {
+	case "help", "-h", "-help", "--help":
+		if len(args) > 1 {
+			if c := lookup(args[1]); c != nil {
+				return Run(c.name, []string{"-h"})
+			}
+		}
+		usage(os.Stdout)
+		return 0
+	default:
+		if lookup(name) == nil {
+			fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+			usage(os.Stderr)
+			return exitUsage
+		}
+		return Run(name, args[1:])
+	}
+}
+
+// Run runs the named command with the given arguments and returns the exit
+// code.
+func Run(name string, args []string) int {
+	c := lookup(name)
+	if c == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", name)
+		return exitUsage
+	}
+	if c.main != nil {
+		return c.main(args)
+	}
+
+	fs := flag.NewFlagSet("mediatool "+c.name, flag.ContinueOnError)
+	o := &options{}
+	o.register(fs, c)
+	ov := overrides{}
+	newProcessor := c.flags(fs, ov)
+	fs.Usage = func() {
+		commandUsage(fs.Output(), fs, c)
+	}
+
+	if err := applyEnv(fs, c.name); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return exitUsage
+	}
+	if err := o.validate(fs); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n\n", err)
+		fs.Usage()
+		return exitUsage
+	}
+
+	return run(c, o, ov.apply(fs), newProcessor)
+}
+
+// applyEnv sets flags from environment variables. A command specific variable
+// MEDIATOOL_<COMMAND>_<FLAG> takes precedence over a shared MEDIATOOL_<FLAG>,
+// flags given on the command line override both.
+func applyEnv(fs *flag.FlagSet, cmd string) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		for _, key := range envKeys(cmd, f.Name) {
+			v, ok := os.LookupEnv(key)
+			if !ok {
+				continue
+			}
+			if setErr := fs.Set(f.Name, v); setErr != nil && err == nil {
+				err = fmt.Errorf("invalid value of %s: %v", key, setErr)
+			}
+			break
+		}
+	})
+	return err
+}
+
+func envKeys(cmd string, flagName string) []string {
+	name := strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(flagName))
+	return []string{
+		envPrefix + strings.ToUpper(cmd) + "_" + name,
+		envPrefix + name,
+	}
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: mediatool <command> [flags]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(w, "\nRun 'mediatool help <command>' for the flags of a command.\n")
+}
+
+func commandUsage(w io.Writer, fs *flag.FlagSet, c *command) {
+	fmt.Fprintf(w, "Usage: mediatool %s [flags] [path ...]\n\n%s\n\nFlags:\n", c.name, c.summary)
+	fs.PrintDefaults()
+	fmt.Fprintf(w, "\nEvery flag can be set by environment variable %s%s_<FLAG> or %s<FLAG>,\n", envPrefix, strings.ToUpper(c.name), envPrefix)
+	fmt.Fprintf(w, "e.g. %s%s_JOBS=2 or %sFFMPEG=/usr/local/bin/ffmpeg.\n", envPrefix, strings.ToUpper(c.name), envPrefix)
+	if c.tool != "" {
+		fmt.Fprintf(w, "\nFiles listed in <dir>/.mediatool-ignore and <dir>/.%s-ignore are skipped.\n", c.tool)
+	}
+}
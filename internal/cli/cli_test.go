@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"flag"
+	"testing"
+	"time"
+)
+
+func TestApplyEnv(t *testing.T) {
+	t.Setenv("MEDIATOOL_JOBS", "2")
+	t.Setenv("MEDIATOOL_PROBE_JOBS", "3")
+	t.Setenv("MEDIATOOL_HEVC_PROBE_JOBS", "4")
+	t.Setenv("MEDIATOOL_NO_CACHE", "true")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	jobs := fs.Int("jobs", 1, "")
+	probeJobs := fs.Int("probe_jobs", 0, "")
+	noCache := fs.Bool("no-cache", false, "")
+	dir := fs.String("dir", "", "")
+
+	if err := applyEnv(fs, "hevc"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Parse([]string{"-jobs", "5"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if *jobs != 5 {
+		t.Errorf("command line should override environment, got jobs %d", *jobs)
+	}
+	if *probeJobs != 4 {
+		t.Errorf("command specific variable should take precedence, got probe_jobs %d", *probeJobs)
+	}
+	if !*noCache {
+		t.Error("expected no-cache set from environment")
+	}
+	if *dir != "" {
+		t.Errorf("unexpected dir %q", *dir)
+	}
+}
+
+func TestApplyEnvInvalid(t *testing.T) {
+	t.Setenv("MEDIATOOL_JOBS", "many")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Int("jobs", 1, "")
+	if err := applyEnv(fs, "ac3"); err == nil {
+		t.Error("expected error for invalid value")
+	}
+}
+
+func TestParseDeadline(t *testing.T) {
+	now := time.Date(2024, 3, 1, 22, 30, 0, 0, time.Local)
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"5h", now.Add(5 * time.Hour)},
+		{"23:15", time.Date(2024, 3, 1, 23, 15, 0, 0, time.Local)},
+		{"06:00", time.Date(2024, 3, 2, 6, 0, 0, 0, time.Local)},
+		{"22:30", time.Date(2024, 3, 2, 22, 30, 0, 0, time.Local)},
+	}
+	for _, tt := range tests {
+		got, err := parseDeadline(tt.in, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseDeadline(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+	if _, err := parseDeadline("tomorrow", now); err == nil {
+		t.Error("expected error for invalid deadline")
+	}
+}
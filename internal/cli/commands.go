@@ -0,0 +1,226 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/hranicka/mediatool/internal"
+	"github.com/hranicka/mediatool/internal/ac3"
+	"github.com/hranicka/mediatool/internal/cleaner"
+	"github.com/hranicka/mediatool/internal/config"
+	"github.com/hranicka/mediatool/internal/dupfinder"
+	"github.com/hranicka/mediatool/internal/hevc"
+	"github.com/hranicka/mediatool/internal/pipeline"
+)
+
+func init() {
+	register(&command{
+		name:    "ac3",
+		summary: "Convert DTS, TrueHD, FLAC or E-AC3 audio to AC3.",
+		tool:    "ac3converter",
+		modify:  true,
+		encode:  true,
+		flags: func(fs *flag.FlagSet, ov overrides) func(cfg config.Config, o *options) processor {
+			ac3Flags(fs, ov)
+			return func(cfg config.Config, o *options) processor {
+				return newAC3(cfg, o)
+			}
+		},
+	})
+
+	register(&command{
+		name:       "hevc",
+		summary:    "Re-encode H.264 video to HEVC using VAAPI.",
+		tool:       "hevcconverter",
+		modify:     true,
+		encode:     true,
+		encodeJobs: 1,
+		flags: func(fs *flag.FlagSet, ov overrides) func(cfg config.Config, o *options) processor {
+			hevcFlags(fs, ov)
+			return func(cfg config.Config, o *options) processor {
+				return newHEVC(cfg, o)
+			}
+		},
+	})
+
+	register(&command{
+		name:    "clean",
+		summary: "Remove streams in languages which are not whitelisted.",
+		tool:    "cleaner",
+		modify:  true,
+		flags: func(fs *flag.FlagSet, ov overrides) func(cfg config.Config, o *options) processor {
+			return func(cfg config.Config, o *options) processor {
+				return newCleaner(cfg, o)
+			}
+		},
+	})
+
+	register(&command{
+		name:       "pipeline",
+		summary:    "Clean, convert audio to AC3 and video to HEVC in a single pass.",
+		tool:       "pipeline",
+		modify:     true,
+		encode:     true,
+		encodeJobs: 1,
+		flags: func(fs *flag.FlagSet, ov overrides) func(cfg config.Config, o *options) processor {
+			steps := stepsFlag{"clean": true, "ac3": true, "hevc": true}
+			fs.Var(steps, "steps", "comma separated list of steps (clean, ac3, hevc)")
+			ac3Flags(fs, ov)
+			hevcFlags(fs, ov)
+
+			return func(cfg config.Config, o *options) processor {
+				return newPipeline(cfg, o, steps)
+			}
+		},
+	})
+
+	register(&command{
+		name:    "dups",
+		summary: "Report possibly duplicated audio tracks.",
+		tool:    "dupfinder",
+		flags: func(fs *flag.FlagSet, ov overrides) func(cfg config.Config, o *options) processor {
+			return func(cfg config.Config, o *options) processor {
+				return &dupfinder.Processor{Exec: internal.CmdExecutor{}}
+			}
+		},
+	})
+
+	register(&command{
+		name:    "serve",
+		summary: "Serve an HTTP API for queueing files and querying their status.",
+		main:    serve,
+	})
+
+	register(&command{
+		name:    "apply",
+		summary: "Execute conversions of a plan written by -plan.",
+		main:    apply,
+	})
+
+	register(&command{
+		name:    "recover",
+		summary: "Resolve leftovers of interrupted conversions.",
+		main:    recoverLeftovers,
+	})
+
+	register(&command{
+		name:    "purge",
+		summary: "Remove expired originals from the backup directory.",
+		main:    purge,
+	})
+
+	register(&command{
+		name:    "config",
+		summary: "Print the effective config of a file or directory.",
+		main:    printConfig,
+	})
+}
+
+func ac3Flags(fs *flag.FlagSet, ov overrides) {
+	def := config.Default().AC3
+
+	var a config.AC3
+	fs.IntVar(&a.MinBitRate, "minbr", def.MinBitRate, "minimal bitrate of track to be considered as valid/already converted")
+	fs.StringVar(&a.Lang, "lang", def.Lang, "yet not converted language to trigger conversion of the whole file")
+	ov["minbr"] = func(c *config.Config) { c.AC3.MinBitRate = a.MinBitRate }
+	ov["lang"] = func(c *config.Config) { c.AC3.Lang = a.Lang }
+}
+
+func newAC3(cfg config.Config, o *options) *ac3.Processor {
+	return &ac3.Processor{
+		Exec:        internal.CmdExecutor{},
+		Lang:        cfg.AC3.Lang,
+		MinBitRate:  cfg.AC3.MinBitRate,
+		Codec:       cfg.AC3.Codec,
+		BitRate:     cfg.AC3.BitRate,
+		Channels:    cfg.AC3.Channels,
+		MinChannels: cfg.AC3.MinChannels,
+		Extensions:  cfg.AC3.Extensions,
+		DryRun:      o.dryRun,
+		Del:         o.del,
+	}
+}
+
+func hevcFlags(fs *flag.FlagSet, ov overrides) {
+	def := config.Default().HEVC
+
+	var h config.HEVC
+	fs.StringVar(&h.VaapiDevice, "vaapi_device", def.VaapiDevice, "ffmpeg vaapi_device")
+	fs.Float64Var(&h.QualityPercent, "quality_percent", def.QualityPercent, "percentage bitrate quality according to source")
+	fs.IntVar(&h.QualityPreset, "quality_preset", def.QualityPreset, "static quality preset (qp) passed to ffmpeg")
+	fs.StringVar(&h.QualityType, "quality_type", def.QualityType, "encoding quality type (auto/qp)")
+	fs.IntVar(&h.Bitrate, "bitrate", def.Bitrate, "encoding quality bitrate (kbps)")
+	ov["vaapi_device"] = func(c *config.Config) { c.HEVC.VaapiDevice = h.VaapiDevice }
+	ov["quality_percent"] = func(c *config.Config) { c.HEVC.QualityPercent = h.QualityPercent }
+	ov["quality_preset"] = func(c *config.Config) { c.HEVC.QualityPreset = h.QualityPreset }
+	ov["quality_type"] = func(c *config.Config) { c.HEVC.QualityType = h.QualityType }
+	ov["bitrate"] = func(c *config.Config) { c.HEVC.Bitrate = h.Bitrate }
+}
+
+func newHEVC(cfg config.Config, o *options) *hevc.Processor {
+	return &hevc.Processor{
+		Exec:              internal.CmdExecutor{},
+		VaapiDevice:       cfg.HEVC.VaapiDevice,
+		EncQualityType:    cfg.HEVC.QualityType,
+		EncQualityPercent: cfg.HEVC.QualityPercent,
+		EncQualityPreset:  cfg.HEVC.QualityPreset,
+		EncBitrate:        cfg.HEVC.Bitrate,
+		Extensions:        cfg.HEVC.Extensions,
+		DryRun:            o.dryRun,
+		Del:               o.del,
+	}
+}
+
+func newCleaner(cfg config.Config, o *options) *cleaner.Processor {
+	return &cleaner.Processor{
+		Exec:       internal.CmdExecutor{},
+		Languages:  cfg.Clean.Languages,
+		Extensions: cfg.Clean.Extensions,
+		DryRun:     o.dryRun,
+		Del:        o.del,
+	}
+}
+
+func newPipeline(cfg config.Config, o *options, steps stepsFlag) *pipeline.Processor {
+	p := &pipeline.Processor{Exec: internal.CmdExecutor{}, DryRun: o.dryRun, Del: o.del}
+	if steps["clean"] {
+		p.Clean = newCleaner(cfg, o)
+	}
+	if steps["ac3"] {
+		p.AC3 = newAC3(cfg, o)
+	}
+	if steps["hevc"] {
+		p.HEVC = newHEVC(cfg, o)
+	}
+	return p
+}
+
+// stepsFlag is a set of enabled pipeline steps.
+type stepsFlag map[string]bool
+
+func (s stepsFlag) String() string {
+	var steps []string
+	for _, name := range []string{"clean", "ac3", "hevc"} {
+		if s[name] {
+			steps = append(steps, name)
+		}
+	}
+	return strings.Join(steps, ",")
+}
+
+func (s stepsFlag) Set(value string) error {
+	for name := range s {
+		delete(s, name)
+	}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		switch name {
+		case "clean", "ac3", "hevc":
+			s[name] = true
+		default:
+			return fmt.Errorf("unknown step %q", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/hranicka/mediatool/internal/config"
+)
+
+// printConfig implements the config command.
+func printConfig(args []string) int {
+	fs := flag.NewFlagSet("mediatool config", flag.ContinueOnError)
+	configFile := fs.String("config", config.DefaultPath(), "config file")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: mediatool config [flags] <path>\n\nPrint the effective config of a file or directory.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return exitUsage
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	// overrides in a directory apply to the directory itself as well
+	path := fs.Arg(0)
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, config.DirFileName)
+	}
+
+	loader, err := config.NewLoader(*configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	cfg, sources, err := loader.For(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+
+	if _, err := os.Stat(*configFile); err == nil {
+		fmt.Printf("# config: %s\n", *configFile)
+	} else {
+		fmt.Printf("# config: %s (not found)\n", *configFile)
+	}
+	for _, s := range sources {
+		fmt.Printf("# override: %s\n", s)
+	}
+	out, err := yaml.Marshal(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	fmt.Print(string(out))
+	return 0
+}
@@ -0,0 +1,309 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hranicka/mediatool/internal"
+	"github.com/hranicka/mediatool/internal/config"
+)
+
+// options are flags shared by all commands.
+type options struct {
+	verbose bool
+
+	file      string
+	dir       string
+	paths     []string
+	filesFrom string
+	ignore    string
+
+	followSymlinks  bool
+	maxDepth        int
+	oneFileSystem   bool
+	dedupeHardlinks bool
+
+	del    bool
+	dryRun bool
+	resume bool
+	plan   string
+
+	jobs       int
+	probeJobs  int
+	encodeJobs int
+
+	order  internal.Order
+	budget internal.Budget
+
+	cacheFile string
+	noCache   bool
+
+	configFile string
+
+	reportJSON string
+	reportCSV  string
+
+	metricsFile   string
+	metricsListen string
+
+	webhook webhookOptions
+
+	watch        bool
+	stableFor    time.Duration
+	debounce     time.Duration
+	pollInterval time.Duration
+}
+
+func (o *options) register(fs *flag.FlagSet, c *command) {
+	fs.BoolVar(&o.verbose, "v", false, "verbose/debug output")
+	fs.StringVar(&internal.FFmpegPath, "ffmpeg", "ffmpeg", "ffmpeg path")
+
+	fs.StringVar(&o.file, "file", "", "source file path, more files and directories can be given as arguments")
+	fs.StringVar(&o.dir, "dir", "", "source files directory, more files and directories can be given as arguments")
+	fs.StringVar(&o.filesFrom, "files_from", "", "read source files and directories from the file, - reads stdin, one per line or NUL separated")
+	fs.StringVar(&o.ignore, "ignore", "", "comma separated list of gitignore patterns relative to source directories")
+	extensionsFlag(fs)
+	o.walkFlags(fs)
+
+	if c.modify {
+		fs.BoolVar(&o.del, "del", false, "delete source files after successful conversion")
+		fs.BoolVar(&o.dryRun, "dry", false, "run in dry mode = without actual conversion")
+		fs.BoolVar(&o.resume, "resume", false, "resume an interrupted -dir run from its journal")
+		fs.StringVar(&o.plan, "plan", "", "write planned conversions to the file instead of converting, see apply")
+		swapFlags(fs)
+	}
+
+	fs.IntVar(&o.jobs, "jobs", 1, "number of files processed concurrently")
+	fs.IntVar(&o.probeJobs, "probe_jobs", 0, "max concurrent cheap operations like probing or remuxing (0 = same as -jobs)")
+	if c.encode {
+		fs.IntVar(&o.encodeJobs, "encode_jobs", c.encodeJobs, "max concurrent encodes (0 = same as -jobs)")
+	}
+	o.budgetFlags(fs)
+
+	fs.StringVar(&o.cacheFile, "cache", internal.DefaultProbeCachePath(), "probe cache file")
+	fs.BoolVar(&o.noCache, "no-cache", false, "do not use the probe cache")
+
+	fs.StringVar(&o.configFile, "config", config.DefaultPath(), "config file, overridden by "+config.DirFileName+" files in the library")
+
+	fs.BoolVar(&o.watch, "watch", false, "keep watching -dir and process new or changed files")
+	fs.DurationVar(&o.stableFor, "stable_for", 30*time.Second, "how long a watched file must not change before it is processed")
+	fs.DurationVar(&o.debounce, "debounce", 2*time.Second, "quiet period after the last change of a watched file before it is checked")
+	fs.DurationVar(&o.pollInterval, "poll_interval", time.Minute, "interval of scanning -dir where file system events are not available")
+
+	fs.StringVar(&o.reportJSON, "report_json", "", "write a JSON Lines report of processed files")
+	fs.StringVar(&o.reportCSV, "report_csv", "", "write a CSV report of processed files")
+
+	fs.StringVar(&o.metricsFile, "metrics_file", "", "write Prometheus metrics to the file, e.g. for the node_exporter textfile collector")
+	fs.StringVar(&o.metricsListen, "metrics_listen", "", "serve Prometheus metrics at /metrics on the address in -watch mode")
+
+	o.webhook.register(fs)
+}
+
+// extensionsFlag registers the flag setting extensions of processed files.
+func extensionsFlag(fs *flag.FlagSet) {
+	fs.Func("extensions", "comma separated list of processed file extensions (default mkv,mp4)", func(s string) error {
+		extensions := internal.ParseExtensions(s)
+		if len(extensions) == 0 {
+			return errors.New("no extension given")
+		}
+		internal.MediaExtensions = extensions
+		return nil
+	})
+}
+
+// walkFlags registers flags configuring the traversal of directories.
+func (o *options) walkFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&o.followSymlinks, "follow_symlinks", false, "follow symlinked directories and files")
+	fs.IntVar(&o.maxDepth, "max_depth", 0, "max depth of processed files, 1 means only files directly in the directory (0 = unlimited)")
+	fs.BoolVar(&o.oneFileSystem, "one_file_system", false, "do not enter directories on other file systems")
+	fs.BoolVar(&o.dedupeHardlinks, "dedupe_hardlinks", false, "process files with multiple hard links only once")
+}
+
+// walkOptions returns options of the traversal of directories.
+func (o *options) walkOptions(ig *internal.Ignorer) internal.WalkOptions {
+	return internal.WalkOptions{
+		Ignores:         ig,
+		FollowSymlinks:  o.followSymlinks,
+		MaxDepth:        o.maxDepth,
+		OneFileSystem:   o.oneFileSystem,
+		DedupeHardlinks: o.dedupeHardlinks,
+	}
+}
+
+// budgetFlags registers flags ordering files and limiting the files started.
+func (o *options) budgetFlags(fs *flag.FlagSet) {
+	fs.Func("order", "order of processed files: largest, oldest, savings (estimated) or random (default walk order)", func(s string) (err error) {
+		o.order, err = internal.ParseOrder(s)
+		return err
+	})
+	fs.IntVar(&o.budget.MaxFiles, "max_files", 0, "max number of files started (0 = unlimited)")
+	fs.Func("max_bytes", "max total size of files started, e.g. 500G (default unlimited)", func(s string) (err error) {
+		o.budget.MaxBytes, err = internal.ParseSize(s)
+		return err
+	})
+	fs.Func("deadline", "no file is started after the time of day like 06:00 or the duration like 5h", func(s string) (err error) {
+		o.budget.Deadline, err = parseDeadline(s, time.Now())
+		return err
+	})
+}
+
+// parseDeadline parses a duration from now or the next occurrence of a time
+// of day.
+func parseDeadline(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(d), nil
+	}
+	t, err := time.ParseInLocation("15:04", s, now.Location())
+	if err != nil {
+		return time.Time{}, errors.New("expected a time of day like 06:00 or a duration like 5h")
+	}
+	deadline := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+	if !deadline.After(now) {
+		deadline = deadline.AddDate(0, 0, 1)
+	}
+	return deadline, nil
+}
+
+// swapFlags registers flags configuring checks of converted files and their
+// swap into place.
+func swapFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&internal.VerifyOutput, "verify", true, "verify converted files before they replace the originals")
+	fs.DurationVar(&internal.VerifyTolerance, "verify_tolerance", internal.VerifyTolerance, "max difference of source and converted duration")
+	fs.BoolVar(&internal.VerifyDecode, "verify_decode", false, "verify converted files by decoding them completely (slow)")
+
+	fs.BoolVar(&internal.CheckSpace, "check_space", true, "skip files whose conversion output would not fit on the disk")
+	fs.Func("reserve", "free space left on the disk after conversion, e.g. 512M or 10G (default 1G)", func(s string) error {
+		size, err := internal.ParseSize(s)
+		if err != nil {
+			return err
+		}
+		internal.SpaceReserve = size
+		return nil
+	})
+
+	fs.StringVar(&internal.BackupDir, "backup_dir", "", "move originals to the directory instead of keeping them as .old files, see purge")
+	fs.BoolVar(&internal.PreserveMetadata, "preserve", true, "copy times, owner, permissions and extended attributes of originals to converted files")
+}
+
+// webhookOptions configure notifications about finished files and batches.
+type webhookOptions struct {
+	urls        []string
+	template    string
+	contentType string
+	timeout     time.Duration
+	retries     int
+}
+
+func (w *webhookOptions) register(fs *flag.FlagSet) {
+	fs.Func("webhook", "URL receiving a POST when a file or batch finishes, can be repeated or comma separated", func(s string) error {
+		for _, url := range strings.Split(s, ",") {
+			if url = strings.TrimSpace(url); url != "" {
+				w.urls = append(w.urls, url)
+			}
+		}
+		return nil
+	})
+	fs.StringVar(&w.template, "webhook_template", "", "text/template file rendering webhook bodies instead of JSON")
+	fs.StringVar(&w.contentType, "webhook_content_type", "application/json", "content type of webhook bodies")
+	fs.DurationVar(&w.timeout, "webhook_timeout", 10*time.Second, "timeout of a single webhook request")
+	fs.IntVar(&w.retries, "webhook_retries", 3, "number of retries of failed webhook requests")
+}
+
+// start starts delivering webhooks, the webhook is nil without URLs.
+func (w *webhookOptions) start() (*internal.Webhook, error) {
+	if len(w.urls) == 0 {
+		return nil, nil
+	}
+	webhook := &internal.Webhook{
+		URLs:        w.urls,
+		ContentType: w.contentType,
+		Timeout:     w.timeout,
+		Retries:     w.retries,
+		RetryDelay:  time.Second,
+		// an interrupted run should not wait long for slow endpoints
+		CloseTimeout: 10 * time.Second,
+	}
+	if w.template != "" {
+		tmpl, err := internal.ParseWebhookTemplate(w.template)
+		if err != nil {
+			return nil, err
+		}
+		webhook.Template = tmpl
+	}
+	webhook.Start()
+	return webhook, nil
+}
+
+func (o *options) validate(fs *flag.FlagSet) error {
+	o.paths = fs.Args()
+	if o.file == "" && o.dir == "" && len(o.paths) == 0 && o.filesFrom == "" {
+		return errors.New("no source files given, use -file, -dir, arguments or -files_from")
+	}
+	if o.watch && (len(o.flagInputs()) != 1 || o.filesFrom != "" || o.plan != "") {
+		return errors.New("-watch requires a single directory and cannot be combined with -plan")
+	}
+	if o.resume && (len(o.flagInputs()) != 1 || o.filesFrom != "") {
+		return errors.New("-resume requires a single directory")
+	}
+	if o.metricsListen != "" && !o.watch {
+		return errors.New("-metrics_listen requires -watch")
+	}
+	if o.maxDepth < 0 {
+		return errors.New("-max_depth cannot be negative")
+	}
+	if o.jobs < 1 {
+		return errors.New("-jobs must be at least 1")
+	}
+	if o.budget.MaxFiles < 0 {
+		return errors.New("-max_files cannot be negative")
+	}
+	return nil
+}
+
+// flagInputs returns source paths given by -file, -dir and arguments.
+func (o *options) flagInputs() []string {
+	var paths []string
+	if o.file != "" {
+		paths = append(paths, o.file)
+	}
+	if o.dir != "" {
+		paths = append(paths, o.dir)
+	}
+	return append(paths, o.paths...)
+}
+
+// inputs returns source paths given by flags, arguments and -files_from.
+func (o *options) inputs() ([]string, error) {
+	paths := o.flagInputs()
+	if o.filesFrom == "" {
+		return paths, nil
+	}
+
+	r := io.Reader(os.Stdin)
+	if o.filesFrom != "-" {
+		f, err := os.Open(o.filesFrom)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	list, err := internal.ReadPaths(r)
+	if err != nil {
+		return nil, err
+	}
+	return append(paths, list...), nil
+}
+
+// inputDir returns the directory when it is the only source path.
+func inputDir(paths []string) (string, bool) {
+	if len(paths) != 1 {
+		return "", false
+	}
+	info, err := os.Stat(paths[0])
+	return paths[0], err == nil && info.IsDir()
+}
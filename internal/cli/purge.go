@@ -0,0 +1,84 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hranicka/mediatool/internal"
+)
+
+// purge implements the purge command expiring originals in the backup
+// directory.
+func purge(args []string) int {
+	fs := flag.NewFlagSet("mediatool purge", flag.ContinueOnError)
+	verbose := fs.Bool("v", false, "verbose/debug output")
+	dir := fs.String("backup_dir", "", "backup directory (required)")
+	dryRun := fs.Bool("dry", false, "only list backups which would be removed")
+	var maxAge time.Duration
+	var maxSize int64
+	fs.Func("max_age", "remove backups older than the age, e.g. 72h or 30d", func(s string) (err error) {
+		maxAge, err = parseAge(s)
+		return err
+	})
+	fs.Func("max_size", "remove the oldest backups until their total size is at most the size, e.g. 500G", func(s string) (err error) {
+		maxSize, err = internal.ParseSize(s)
+		return err
+	})
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: mediatool purge [flags]\n\nRemove originals from the backup directory according to the retention policy.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := applyEnv(fs, "purge"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return exitUsage
+	}
+	if fs.NArg() > 0 || *dir == "" || (maxAge == 0 && maxSize == 0) {
+		fmt.Fprintf(os.Stderr, "-backup_dir and at least one of -max_age or -max_size are required\n\n")
+		fs.Usage()
+		return exitUsage
+	}
+
+	internal.SetupLogging(*verbose)
+	if *dryRun {
+		slog.Info("DRY RUN")
+	}
+
+	expired, err := internal.PurgeBackups(*dir, maxAge, maxSize, *dryRun)
+	if err != nil {
+		slog.Error("cannot purge backups", "error", err)
+		return exitFailure
+	}
+
+	var size int64
+	for _, e := range expired {
+		slog.Info("removing backup", "original", e.Original, "backup", e.Backup, "time", e.Time, "dry", *dryRun)
+		size += e.Size
+	}
+	slog.Info("purge finished", "removed", len(expired), "size", internal.FormatSize(size))
+	return 0
+}
+
+// parseAge parses a duration, accepting days as well.
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/hranicka/mediatool/internal"
+)
+
+// recoverLeftovers implements the recover command resolving leftovers of
+// interrupted conversions in a directory tree.
+func recoverLeftovers(args []string) int {
+	flags := flag.NewFlagSet("mediatool recover", flag.ContinueOnError)
+	verbose := flags.Bool("v", false, "verbose/debug output")
+	dryRun := flags.Bool("dry", false, "only list leftovers and how they would be resolved")
+	del := flags.Bool("del", false, "delete originals (.old) kept next to converted files")
+	extensionsFlag(flags)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: mediatool recover [flags] <dir>\n\nResolve leftovers of interrupted conversions (.tmp.mkv, .tmp.mp4, .old, .swap)\nof files of known containers and -extensions. Logged swaps are finished,\nunfinished conversions are removed and missing sources are restored from\ntheir originals.\n\nFlags:\n")
+		flags.PrintDefaults()
+	}
+
+	if err := applyEnv(flags, "recover"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return exitUsage
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	internal.SetupLogging(*verbose)
+	if *dryRun {
+		slog.Info("DRY RUN")
+	}
+
+	var sources []string
+	seen := make(map[string]bool)
+	err := filepath.WalkDir(flags.Arg(0), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			slog.Warn("cannot read path", "path", path, "error", err)
+			return nil
+		}
+		if src, ok := internal.LeftoverSource(path); ok && !d.IsDir() && !seen[src] {
+			seen[src] = true
+			sources = append(sources, src)
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("cannot scan directory", "error", err)
+		return exitFailure
+	}
+
+	code := 0
+	for _, src := range sources {
+		res, err := internal.ResolveSwap(src, *del, *dryRun)
+		if err != nil {
+			slog.Error("cannot resolve leftovers", "file", src, "error", err)
+			code = exitFailure
+			continue
+		}
+		slog.Info("resolving leftovers", "file", src, "resolution", res, "dry", *dryRun)
+	}
+	slog.Info("recover finished", "files", len(sources))
+	return code
+}
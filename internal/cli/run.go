@@ -0,0 +1,224 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hranicka/mediatool/internal"
+	"github.com/hranicka/mediatool/internal/config"
+)
+
+// processor processes a single media file.
+type processor interface {
+	Process(ctx context.Context, path string) error
+}
+
+// run processes files selected by options and returns the exit code. Every
+// file is processed by a processor created with its effective config.
+func run(c *command, o *options, override func(c *config.Config), newProcessor func(cfg config.Config, o *options) processor) int {
+	internal.SetupLogging(o.verbose)
+
+	loader, err := config.NewLoader(o.configFile)
+	if err != nil {
+		slog.Error("cannot load config", "error", err)
+		return exitUsage
+	}
+	loader.Override = override
+
+	if _, err := ignorer(c, o, ""); err != nil {
+		slog.Error("cannot parse -ignore", "error", err)
+		return exitUsage
+	}
+	walkOptions := func(dir string) internal.WalkOptions {
+		ig, _ := ignorer(c, o, dir) // patterns are checked above
+		return o.walkOptions(ig)
+	}
+
+	inputs, err := o.inputs()
+	if err != nil {
+		slog.Error("cannot read -files_from", "error", err)
+		return exitUsage
+	}
+	dir, isDir := inputDir(inputs)
+	if (o.watch || o.resume) && !isDir {
+		slog.Error("-watch and -resume require a directory", "path", dir)
+		return exitUsage
+	}
+
+	if o.dryRun {
+		slog.Info("DRY RUN")
+	}
+
+	internal.SetLimits(o.jobs, o.probeJobs, o.encodeJobs)
+
+	walkCtx, runCtx, stop := internal.NotifyContext(context.Background())
+	defer stop()
+
+	closeCache := internal.SetupProbeCache(o.cacheFile, o.noCache)
+	defer closeCache()
+
+	report, err := internal.OpenReport(o.reportJSON, o.reportCSV)
+	if err != nil {
+		slog.Error("cannot open report", "error", err)
+		return exitFailure
+	}
+
+	webhook, err := o.webhook.start()
+	if err != nil {
+		slog.Error("cannot load webhook template", "error", err)
+		return exitUsage
+	}
+	if webhook != nil {
+		defer webhook.Close()
+	}
+
+	var metrics *internal.Metrics
+	if o.metricsFile != "" || o.metricsListen != "" {
+		metrics = internal.NewMetrics()
+	}
+	writeMetrics := func() {
+		if o.metricsFile == "" {
+			return
+		}
+		if err := metrics.WriteFile(o.metricsFile); err != nil {
+			slog.Warn("cannot write metrics", "error", err)
+		}
+	}
+
+	var plan *internal.Plan
+	if o.plan != "" {
+		if plan, err = internal.CreatePlan(o.plan); err != nil {
+			slog.Error("cannot open plan", "error", err)
+			return exitFailure
+		}
+	}
+
+	var files []internal.File
+	var walkErrs []error
+	collect := func() []internal.File {
+		files, walkErrs = internal.CollectPaths(walkCtx, inputs, walkOptions)
+		return files
+	}
+	var journal *internal.Journal
+	if !isDir || !c.modify || o.dryRun || plan != nil {
+		files = collect()
+	} else {
+		journal, files = internal.StartJournal(filepath.Join(dir, "."+c.tool+"-journal"), o.resume, collect)
+	}
+
+	process := func(ctx context.Context, path string) error {
+		cfg, sources, err := loader.For(path)
+		if err != nil {
+			return err
+		}
+		if len(sources) > 0 {
+			slog.DebugContext(ctx, "using directory config", "file", path, "sources", sources)
+		}
+		return newProcessor(cfg, o).Process(ctx, path)
+	}
+
+	runner := &internal.Runner{Jobs: o.jobs, Journal: journal, Report: report, Command: c.name, DryRun: o.dryRun, Plan: plan, Metrics: metrics, Webhook: webhook, Order: o.order, Budget: o.budget}
+	summary := runner.Run(walkCtx, runCtx, files, process)
+	if summary.Unreadable = len(walkErrs); summary.Unreadable > 0 {
+		slog.Warn("some paths could not be read", "unreadable", summary.Unreadable)
+	}
+	writeMetrics()
+	if webhook != nil {
+		webhook.BatchFinished(c.name, summary)
+	}
+
+	if journal != nil {
+		if err := journal.Close(summary.Complete()); err != nil {
+			slog.Warn("cannot close journal", "error", err)
+		}
+	}
+
+	if o.watch {
+		// the watch ends by an interrupt, which does not make it incomplete
+		failed := summary.Failed
+		if o.metricsListen != "" {
+			defer serveMetrics(o.metricsListen, metrics)()
+		}
+		runner := &internal.Runner{Jobs: o.jobs, Report: report, Command: c.name, DryRun: o.dryRun, Metrics: metrics, Webhook: webhook, Order: o.order}
+		err := internal.Watch(walkCtx, dir, internal.WatchOptions{
+			StableFor:    o.stableFor,
+			Debounce:     o.debounce,
+			PollInterval: o.pollInterval,
+			Ignores:      walkOptions(dir).Ignores,
+			// files arriving during the initial batch were not watched yet
+			Rescan: true,
+			Known:  files,
+		}, func(files []internal.File) {
+			batch := runner.Run(walkCtx, runCtx, files, process)
+			failed += batch.Failed
+			writeMetrics()
+			if webhook != nil {
+				webhook.BatchFinished(c.name, batch)
+			}
+		})
+		if err != nil {
+			slog.Error("cannot watch directory", "dir", dir, "error", err)
+			failed++
+		}
+		summary = internal.Summary{Failed: failed, Unreadable: summary.Unreadable}
+	}
+
+	if plan != nil {
+		n, err := plan.Close()
+		if err != nil {
+			slog.Error("cannot write plan", "error", err)
+			return exitFailure
+		}
+		slog.Info("plan written", "path", o.plan, "entries", n)
+	}
+
+	if report != nil {
+		if err := report.Close(); err != nil {
+			slog.Warn("cannot close report", "error", err)
+		}
+	}
+
+	// files postponed by the budget are left for the next run
+	if summary.Failed > 0 || summary.Unreadable > 0 || summary.Interrupted > 0 || summary.Skipped > 0 {
+		return exitFailure
+	}
+	return 0
+}
+
+// serveMetrics serves metrics at /metrics on the address until the returned
+// function is called.
+func serveMetrics(addr string, metrics *internal.Metrics) (stop func()) {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metrics)
+	srv := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		slog.Info("serving metrics", "address", addr)
+		if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("cannot serve metrics", "error", err)
+		}
+	}()
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(ctx)
+	}
+}
+
+// ignorer returns an Ignorer of the directory using ignore files shared by all
+// commands, specific to the command and patterns given by -ignore.
+func ignorer(c *command, o *options, dir string) (*internal.Ignorer, error) {
+	names := []string{".mediatool-ignore"}
+	if c.tool != "" {
+		names = append(names, "."+c.tool+"-ignore")
+	}
+	var patterns []string
+	if o.ignore != "" {
+		patterns = strings.Split(o.ignore, ",")
+	}
+	return internal.NewIgnorer(dir, names, patterns)
+}
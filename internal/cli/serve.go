@@ -0,0 +1,178 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hranicka/mediatool/internal"
+	"github.com/hranicka/mediatool/internal/config"
+	"github.com/hranicka/mediatool/internal/server"
+)
+
+// serve implements the serve command running the HTTP API.
+func serve(args []string) int {
+	fs := flag.NewFlagSet("mediatool serve", flag.ContinueOnError)
+	var o options
+	listen := fs.String("listen", "localhost:8080", "address of the HTTP API")
+	root := fs.String("root", "", "only accept files within the directory")
+	queueSize := fs.Int("queue", 1000, "max number of queued files")
+	history := fs.Int("history", 1000, "number of finished jobs kept for listing")
+	fs.BoolVar(&o.verbose, "v", false, "verbose/debug output")
+	fs.StringVar(&internal.FFmpegPath, "ffmpeg", "ffmpeg", "ffmpeg path")
+	fs.StringVar(&o.ignore, "ignore", "", "comma separated list of gitignore patterns applied in queued directories")
+	extensionsFlag(fs)
+	o.walkFlags(fs)
+	fs.BoolVar(&o.del, "del", false, "delete source files after successful conversion")
+	fs.BoolVar(&o.dryRun, "dry", false, "run in dry mode = without actual conversion")
+	swapFlags(fs)
+	fs.IntVar(&o.jobs, "jobs", 1, "number of files processed concurrently")
+	fs.IntVar(&o.probeJobs, "probe_jobs", 0, "max concurrent cheap operations like probing or remuxing (0 = same as -jobs)")
+	fs.IntVar(&o.encodeJobs, "encode_jobs", 1, "max concurrent encodes (0 = same as -jobs)")
+	fs.StringVar(&o.cacheFile, "cache", internal.DefaultProbeCachePath(), "probe cache file")
+	fs.BoolVar(&o.noCache, "no-cache", false, "do not use the probe cache")
+	fs.StringVar(&o.configFile, "config", config.DefaultPath(), "config file, overridden by "+config.DirFileName+" files in the library")
+	fs.StringVar(&o.reportJSON, "report_json", "", "write a JSON Lines report of processed files")
+	fs.StringVar(&o.reportCSV, "report_csv", "", "write a CSV report of processed files")
+	o.webhook.register(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: mediatool serve [flags]\n\nServe an HTTP API for queueing files and querying their status:\n\n"+
+			"  POST   /jobs        queue {\"command\": \"ac3|hevc|clean|pipeline\", \"path\": \"file or directory\"}\n"+
+			"  GET    /jobs        list queued, running and finished jobs, ?state= filters them\n"+
+			"  GET    /jobs/{id}   get a job\n"+
+			"  DELETE /jobs/{id}   cancel a queued or running job\n"+
+			"  GET    /probe       probe results of ?path=\n"+
+			"  GET    /metrics     Prometheus metrics\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := applyEnv(fs, "serve"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return exitUsage
+	}
+	if fs.NArg() > 0 || o.jobs < 1 || o.maxDepth < 0 || *queueSize < 1 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	internal.SetupLogging(o.verbose)
+
+	loader, err := config.NewLoader(o.configFile)
+	if err != nil {
+		slog.Error("cannot load config", "error", err)
+		return exitUsage
+	}
+
+	if o.dryRun {
+		slog.Info("DRY RUN")
+	}
+
+	internal.SetLimits(o.jobs, o.probeJobs, o.encodeJobs)
+
+	walkCtx, runCtx, stop := internal.NotifyContext(context.Background())
+	defer stop()
+
+	closeCache := internal.SetupProbeCache(o.cacheFile, o.noCache)
+	defer closeCache()
+
+	report, err := internal.OpenReport(o.reportJSON, o.reportCSV)
+	if err != nil {
+		slog.Error("cannot open report", "error", err)
+		return exitFailure
+	}
+
+	webhook, err := o.webhook.start()
+	if err != nil {
+		slog.Error("cannot load webhook template", "error", err)
+		return exitUsage
+	}
+	if webhook != nil {
+		defer webhook.Close()
+	}
+
+	var ignores []string
+	if o.ignore != "" {
+		ignores = strings.Split(o.ignore, ",")
+	}
+
+	srv := &server.Server{
+		Commands: []string{"ac3", "hevc", "clean", "pipeline"},
+		New: func(command string, path string) (server.Processor, error) {
+			cfg, sources, err := loader.For(path)
+			if err != nil {
+				return nil, err
+			}
+			if len(sources) > 0 {
+				slog.Debug("using directory config", "file", path, "sources", sources)
+			}
+			switch command {
+			case "ac3":
+				return newAC3(cfg, &o), nil
+			case "hevc":
+				return newHEVC(cfg, &o), nil
+			case "clean":
+				return newCleaner(cfg, &o), nil
+			default:
+				return newPipeline(cfg, &o, stepsFlag{"clean": true, "ac3": true, "hevc": true}), nil
+			}
+		},
+		Exec:        internal.CmdExecutor{},
+		Runner:      &internal.Runner{Jobs: o.jobs, Report: report, DryRun: o.dryRun, Metrics: internal.NewMetrics(), Webhook: webhook},
+		Root:        *root,
+		Walk:        o.walkOptions(nil),
+		IgnoreFiles: []string{".mediatool-ignore"},
+		Ignores:     ignores,
+		QueueSize:   *queueSize,
+		HistorySize: *history,
+	}
+
+	// serving ends by an interrupt or when the listener fails
+	serveCtx, cancel := context.WithCancel(walkCtx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		srv.Run(serveCtx, runCtx)
+	}()
+
+	httpServer := &http.Server{Addr: *listen, Handler: srv.Handler()}
+	go func() {
+		<-serveCtx.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := httpServer.Shutdown(ctx); err != nil {
+			slog.Warn("cannot shut down HTTP server", "error", err)
+		}
+	}()
+
+	slog.Info("serving HTTP API", "address", *listen)
+	code := 0
+	if err := httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("cannot serve HTTP API", "error", err)
+		cancel()
+		code = exitFailure
+	}
+	wg.Wait()
+
+	if report != nil {
+		if err := report.Close(); err != nil {
+			slog.Warn("cannot close report", "error", err)
+		}
+	}
+	return code
+}
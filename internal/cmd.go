@@ -3,21 +3,81 @@ package internal
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"os/exec"
+	"time"
 )
 
-func RunCmd(name string, arg ...string) ([]byte, error) {
+// cmdWaitDelay is how long an interrupted command may take to release its
+// output pipes before they are forcibly closed.
+const cmdWaitDelay = 5 * time.Second
+
+// Executor runs external commands.
+type Executor interface {
+	// Output runs the command and returns its standard output.
+	Output(ctx context.Context, name string, arg ...string) ([]byte, error)
+	// Stream runs the command and passes its standard output to fn while the
+	// command is running.
+	Stream(ctx context.Context, fn func(r io.Reader) error, name string, arg ...string) error
+}
+
+// CmdExecutor is an Executor running real processes.
+type CmdExecutor struct{}
+
+func (CmdExecutor) Output(ctx context.Context, name string, arg ...string) ([]byte, error) {
 	var cmdOut bytes.Buffer
 	var cmdErr bytes.Buffer
-	cmd := exec.Command(name, arg...)
+	cmd := command(ctx, name, arg...)
 	cmd.Stdout = &cmdOut
 	cmd.Stderr = &cmdErr
 
-	slog.Debug("running command", "cmd", cmd.String())
+	slog.DebugContext(ctx, "running command", "cmd", cmd.String())
 	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("%v: %s", err, cmdErr.String())
+		return nil, cmdError(ctx, err, &cmdErr)
 	}
 	return cmdOut.Bytes(), nil
 }
+
+func (CmdExecutor) Stream(ctx context.Context, fn func(r io.Reader) error, name string, arg ...string) error {
+	var cmdErr bytes.Buffer
+	cmd := command(ctx, name, arg...)
+	cmd.Stderr = &cmdErr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("cannot open command output: %v", err)
+	}
+
+	slog.DebugContext(ctx, "running command", "cmd", cmd.String())
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("cannot start command: %v", err)
+	}
+
+	fnErr := fn(stdout)
+	_, _ = io.Copy(io.Discard, stdout)
+
+	if err := cmd.Wait(); err != nil {
+		return cmdError(ctx, err, &cmdErr)
+	}
+	if fnErr != nil {
+		return fmt.Errorf("cannot read command output: %v", fnErr)
+	}
+	return nil
+}
+
+func command(ctx context.Context, name string, arg ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, arg...)
+	cmd.WaitDelay = cmdWaitDelay
+	detachProcessGroup(cmd)
+	return cmd
+}
+
+func cmdError(ctx context.Context, err error, stderr *bytes.Buffer) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("%w: %v", ctxErr, err)
+	}
+	return fmt.Errorf("%v: %s", err, stderr.String())
+}
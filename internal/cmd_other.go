@@ -0,0 +1,9 @@
+//go:build !unix
+
+package internal
+
+import (
+	"os/exec"
+)
+
+func detachProcessGroup(_ *exec.Cmd) {}
@@ -0,0 +1,15 @@
+//go:build unix
+
+package internal
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// detachProcessGroup starts the command in its own process group, so a Ctrl+C
+// in the terminal reaches only this application and the child is stopped
+// exclusively through the command context.
+func detachProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
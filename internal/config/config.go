@@ -0,0 +1,190 @@
+// Package config loads processor settings from the user config file and
+// per-directory overrides placed in the media library.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/hranicka/mediatool/internal/cleaner"
+	"github.com/hranicka/mediatool/internal/hevc"
+)
+
+const (
+	// FileName is the name of the user config file.
+	FileName = "mediatool.yaml"
+	// DirFileName is the name of a per-directory override file, it applies to
+	// files in the directory and all its subdirectories.
+	DirFileName = ".mediatool.yaml"
+)
+
+// Config contains settings of all processors.
+type Config struct {
+	AC3   AC3   `yaml:"ac3"`
+	HEVC  HEVC  `yaml:"hevc"`
+	Clean Clean `yaml:"clean"`
+}
+
+type AC3 struct {
+	Lang        string   `yaml:"lang"`
+	MinBitRate  int      `yaml:"minbr"`
+	Codec       string   `yaml:"codec"`
+	BitRate     string   `yaml:"bitrate"`
+	Channels    int      `yaml:"channels"`
+	MinChannels int      `yaml:"min_channels"`
+	Extensions  []string `yaml:"extensions"`
+}
+
+type HEVC struct {
+	VaapiDevice    string   `yaml:"vaapi_device"`
+	QualityType    string   `yaml:"quality_type"`
+	QualityPercent float64  `yaml:"quality_percent"`
+	QualityPreset  int      `yaml:"quality_preset"`
+	Bitrate        int      `yaml:"bitrate"`
+	Extensions     []string `yaml:"extensions"`
+}
+
+type Clean struct {
+	Languages  []string `yaml:"languages"`
+	Extensions []string `yaml:"extensions"`
+}
+
+// Default returns the built-in settings.
+func Default() Config {
+	return Config{
+		AC3: AC3{
+			MinBitRate: 448000,
+			Codec:      "ac3",
+			BitRate:    "640k",
+		},
+		HEVC: HEVC{
+			VaapiDevice:    "/dev/dri/renderD128",
+			QualityType:    hevc.EncQualityTypeQP,
+			QualityPercent: 0.6,
+			QualityPreset:  18,
+		},
+		Clean: Clean{
+			Languages: append([]string(nil), cleaner.DefaultLanguages...),
+		},
+	}
+}
+
+// DefaultPath returns the location of the user config file, or an empty
+// string if it is unknown.
+func DefaultPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "mediatool", FileName)
+}
+
+// Loader resolves the effective config of media files. Settings are layered:
+// built-in defaults, the user config file, override files of all parent
+// directories from the top down, and finally Override.
+type Loader struct {
+	// Override applies settings which take precedence over all files, e.g.
+	// explicitly given flags.
+	Override func(c *Config)
+
+	base Config
+
+	mu   sync.Mutex
+	dirs map[string][]byte
+}
+
+// NewLoader creates a loader using the user config file at path. A missing
+// file is not an error.
+func NewLoader(path string) (*Loader, error) {
+	l := &Loader{base: Default(), dirs: make(map[string][]byte)}
+	if path == "" {
+		return l, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read config: %w", err)
+	}
+	if err := yaml.Unmarshal(data, &l.base); err != nil {
+		return nil, fmt.Errorf("cannot parse config %s: %w", path, err)
+	}
+
+	slog.Debug("config loaded", "path", path)
+	return l, nil
+}
+
+// For returns the effective config of the file at path along with the override
+// files which were applied.
+func (l *Loader) For(path string) (Config, []string, error) {
+	c := l.base
+	c.Clean.Languages = append([]string(nil), c.Clean.Languages...)
+
+	var sources []string
+	for _, dir := range parents(path) {
+		data, err := l.dirFile(dir)
+		if err != nil {
+			return Config{}, nil, err
+		}
+		if data == nil {
+			continue
+		}
+
+		file := filepath.Join(dir, DirFileName)
+		if err := yaml.Unmarshal(data, &c); err != nil {
+			return Config{}, nil, fmt.Errorf("cannot parse config %s: %w", file, err)
+		}
+		sources = append(sources, file)
+	}
+
+	if l.Override != nil {
+		l.Override(&c)
+	}
+	return c, sources, nil
+}
+
+// dirFile returns the content of the override file in dir, or nil if there is
+// none. Contents are cached, so every directory is read only once.
+func (l *Loader) dirFile(dir string) ([]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if data, ok := l.dirs[dir]; ok {
+		return data, nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, DirFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		data, err = nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read config: %w", err)
+	}
+	l.dirs[dir] = data
+	return data, nil
+}
+
+// parents returns directories containing path, from the root down.
+func parents(path string) []string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+
+	var dirs []string
+	for dir := filepath.Dir(abs); ; dir = filepath.Dir(dir) {
+		dirs = append([]string{dir}, dirs...)
+		if parent := filepath.Dir(dir); parent == dir {
+			break
+		}
+	}
+	return dirs
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoaderFor(t *testing.T) {
+	root := t.TempDir()
+	write := func(path string, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	user := filepath.Join(root, "user", FileName)
+	write(user, "ac3:\n  lang: cze\nhevc:\n  quality_preset: 22\n")
+	write(filepath.Join(root, "lib", "anime", DirFileName), "clean:\n  languages: [eng, jpn]\n")
+	write(filepath.Join(root, "lib", "anime", "kids", DirFileName), "ac3:\n  codec: aac\n  bitrate: 192k\n  channels: 2\n")
+
+	l, err := NewLoader(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Override = func(c *Config) {
+		c.AC3.MinBitRate = 128000
+	}
+
+	cfg, sources, err := l.For(filepath.Join(root, "lib", "anime", "kids", "show.mkv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Default()
+	want.AC3 = AC3{Lang: "cze", MinBitRate: 128000, Codec: "aac", BitRate: "192k", Channels: 2}
+	want.HEVC.QualityPreset = 22
+	want.Clean.Languages = []string{"eng", "jpn"}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("unexpected config:\n got %+v\nwant %+v", cfg, want)
+	}
+	if len(sources) != 2 {
+		t.Errorf("expected 2 override files, got %v", sources)
+	}
+
+	// overrides do not leak to other directories
+	cfg, sources, err = l.For(filepath.Join(root, "lib", "movie.mkv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sources) != 0 || cfg.AC3.Codec != "ac3" || !reflect.DeepEqual(cfg.Clean.Languages, Default().Clean.Languages) {
+		t.Errorf("unexpected config %+v from %v", cfg, sources)
+	}
+}
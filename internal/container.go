@@ -0,0 +1,170 @@
+package internal
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Container describes a container format and the codecs it can hold.
+type Container struct {
+	Name string
+	// Muxer is the ffmpeg output format.
+	Muxer      string
+	Extensions []string
+	// Video, Audio and Subtitle are codecs the container can carry, nil means
+	// any codec.
+	Video    []string
+	Audio    []string
+	Subtitle []string
+}
+
+var (
+	// MediaExtensions are extensions of files found in directories and
+	// accepted by processors without their own list.
+	MediaExtensions = []string{".mkv", ".mp4"}
+
+	// RemuxExtension is the container files are remuxed into when their own
+	// cannot carry the converted streams.
+	RemuxExtension = ".mkv"
+
+	// Containers is the capability table of known containers.
+	Containers = []Container{
+		{Name: "matroska", Muxer: "matroska", Extensions: []string{".mkv", ".mka"}},
+		{
+			Name: "mp4", Muxer: "mp4", Extensions: []string{".mp4", ".m4v"},
+			Video:    []string{CodecH264, CodecHEVC, "av1", "vp9", "mpeg4", "mpeg2video", "mjpeg", "png"},
+			Audio:    []string{CodecAAC, CodecAC3, CodecEAC3, "mp3", "opus", CodecFLAC, "alac", CodecDTS},
+			Subtitle: []string{"mov_text"},
+		},
+		{
+			Name: "mov", Muxer: "mov", Extensions: []string{".mov"},
+			Video:    []string{CodecH264, CodecHEVC, "mpeg4", "prores", "mjpeg"},
+			Audio:    []string{CodecAAC, CodecAC3, CodecEAC3, "alac", "mp3", "pcm_s16le", "pcm_s24le"},
+			Subtitle: []string{"mov_text"},
+		},
+		{
+			Name: "avi", Muxer: "avi", Extensions: []string{".avi"},
+			Video:    []string{CodecH264, "mpeg4", "msmpeg4v3", "mjpeg"},
+			Audio:    []string{"mp3", "mp2", CodecAC3, "pcm_s16le"},
+			Subtitle: []string{},
+		},
+		{
+			Name: "mpegts", Muxer: "mpegts", Extensions: []string{".ts", ".m2ts", ".mts"},
+			Video:    []string{CodecH264, CodecHEVC, "mpeg2video"},
+			Audio:    []string{CodecAAC, CodecAC3, CodecEAC3, "mp2", "mp3", CodecDTS, CodecTrueHD, "opus"},
+			Subtitle: []string{"dvb_subtitle"},
+		},
+		{
+			Name: "webm", Muxer: "webm", Extensions: []string{".webm"},
+			Video:    []string{"vp8", "vp9", "av1"},
+			Audio:    []string{"vorbis", "opus"},
+			Subtitle: []string{"webvtt"},
+		},
+		{
+			Name: "asf", Muxer: "asf", Extensions: []string{".wmv", ".asf"},
+			Video:    []string{"wmv1", "wmv2", "wmv3", "vc1", CodecH264, "mpeg4"},
+			Audio:    []string{"wmav1", "wmav2", "wmapro", "mp3", CodecAC3},
+			Subtitle: []string{},
+		},
+	}
+)
+
+// ContainerOf returns the container of the file by its extension, or nil when
+// it is not known.
+func ContainerOf(path string) *Container {
+	ext := strings.ToLower(filepath.Ext(path))
+	for i, c := range Containers {
+		if slices.Contains(c.Extensions, ext) {
+			return &Containers[i]
+		}
+	}
+	return nil
+}
+
+// Supports reports whether the container can carry the stream. Streams other
+// than video, audio and subtitles, e.g. attachments, are not checked.
+func (c *Container) Supports(s OutputStream) bool {
+	var codecs []string
+	switch s.Type {
+	case TypeVideo:
+		codecs = c.Video
+	case TypeAudio:
+		codecs = c.Audio
+	case TypeSubtitles:
+		codecs = c.Subtitle
+	default:
+		return true
+	}
+	return codecs == nil || slices.Contains(codecs, s.Codec)
+}
+
+// OutputPath returns the path the conversion of src is stored at. It is src
+// unless its container, or an unknown one, cannot carry the output streams,
+// then the file is remuxed into RemuxExtension. Nil streams keep src.
+func OutputPath(src string, streams []OutputStream) string {
+	if streams == nil {
+		return src
+	}
+	if c := ContainerOf(src); c != nil {
+		supported := true
+		for _, s := range streams {
+			supported = supported && c.Supports(s)
+		}
+		if supported {
+			return src
+		}
+	}
+	return remuxPath(src)
+}
+
+// remuxPath returns the path of src remuxed into RemuxExtension.
+func remuxPath(src string) string {
+	return strings.TrimSuffix(src, filepath.Ext(src)) + RemuxExtension
+}
+
+// CheckExtension returns an error when the extension of src is not among the
+// extensions, MediaExtensions are used when there are none.
+func CheckExtension(src string, extensions []string) error {
+	if !hasExtension(src, extensions) {
+		return fmt.Errorf("unsupported file format: %s", src)
+	}
+	return nil
+}
+
+// isMediaName reports whether the extension of path is among MediaExtensions.
+func isMediaName(path string) bool {
+	return hasExtension(path, nil)
+}
+
+func hasExtension(path string, extensions []string) bool {
+	if len(extensions) == 0 {
+		extensions = MediaExtensions
+	}
+	// extensions in the config may be given without the dot
+	ext := strings.TrimPrefix(filepath.Ext(path), ".")
+	for _, e := range extensions {
+		if ext != "" && strings.EqualFold(ext, strings.TrimPrefix(e, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseExtensions parses a comma separated list of extensions with or without
+// the leading dot.
+func ParseExtensions(s string) []string {
+	var extensions []string
+	for _, e := range strings.Split(s, ",") {
+		e = strings.ToLower(strings.TrimSpace(e))
+		if e == "" {
+			continue
+		}
+		if !strings.HasPrefix(e, ".") {
+			e = "." + e
+		}
+		extensions = append(extensions, e)
+	}
+	return extensions
+}
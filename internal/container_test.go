@@ -0,0 +1,109 @@
+package internal
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestOutputPath(t *testing.T) {
+	hevc := OutputStream{Type: TypeVideo, Codec: CodecHEVC}
+	ac3 := OutputStream{Type: TypeAudio, Codec: CodecAC3}
+	srt := OutputStream{Type: TypeSubtitles, Codec: "subrip"}
+	font := OutputStream{Type: "attachment", Codec: "ttf"}
+
+	tests := []struct {
+		src     string
+		streams []OutputStream
+		want    string
+	}{
+		{"/m/a.mkv", []OutputStream{hevc, ac3, srt, font}, "/m/a.mkv"},
+		{"/m/a.mp4", []OutputStream{hevc, ac3}, "/m/a.mp4"},
+		{"/m/a.MP4", []OutputStream{hevc, srt}, "/m/a.mkv"},
+		{"/m/a.avi", []OutputStream{ac3}, "/m/a.avi"},
+		{"/m/a.avi", []OutputStream{hevc, ac3}, "/m/a.mkv"},
+		{"/m/a.webm", []OutputStream{ac3}, "/m/a.mkv"},
+		{"/m/a.ts", []OutputStream{hevc, ac3}, "/m/a.ts"},
+		{"/m/a.flv", []OutputStream{ac3}, "/m/a.mkv"},
+		{"/m/a.flv", nil, "/m/a.flv"},
+	}
+	for _, tt := range tests {
+		if got := OutputPath(tt.src, tt.streams); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.src, got, tt.want)
+		}
+	}
+
+	if err := CheckExtension("/m/a.avi", nil); err == nil {
+		t.Error("avi accepted by default")
+	}
+	if err := CheckExtension("/m/a.AVI", ParseExtensions("mkv, .avi")); err != nil {
+		t.Error(err)
+	}
+	if err := CheckExtension("/m/a.ts", []string{"ts"}); err != nil {
+		t.Error(err)
+	}
+}
+
+// touchExecutor creates the output of ffmpeg and records its arguments.
+type touchExecutor struct {
+	args [][]string
+}
+
+func (e *touchExecutor) Output(context.Context, string, ...string) ([]byte, error) {
+	return nil, nil
+}
+
+func (e *touchExecutor) Stream(_ context.Context, _ func(r io.Reader) error, _ string, arg ...string) error {
+	e.args = append(e.args, arg)
+	return os.WriteFile(arg[len(arg)-1], []byte("converted"), 0o644)
+}
+
+func TestExecuteRemux(t *testing.T) {
+	VerifyOutput, CheckSpace = false, false
+	t.Cleanup(func() { VerifyOutput, CheckSpace = true, true })
+	MediaExtensions = []string{".mkv", ".avi"}
+	t.Cleanup(func() { MediaExtensions = []string{".mkv", ".mp4"} })
+
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "a.avi"), filepath.Join(dir, "a.mkv")
+	if err := os.WriteFile(src, []byte("avi"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	e := &touchExecutor{}
+	entry := &PlanEntry{
+		File:   src,
+		Class:  Cheap,
+		Args:   []string{"-i", src, "-map", "0", "-c:v", "hevc_vaapi", TempPath(src)},
+		Output: []OutputStream{{Type: TypeVideo, Codec: CodecHEVC}},
+	}
+	if err := Execute(context.Background(), e, entry, false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	args := e.args[0]
+	if entry.Dst != dst || !slices.Equal(args[len(args)-3:], []string{"-f", "matroska", TempPath(src)}) {
+		t.Errorf("got dst %s, args %v", entry.Dst, args)
+	}
+	if !exists(dst) || exists(src) || !exists(OldPath(src)) || exists(IntentPath(src)) {
+		t.Error("unexpected files after remux")
+	}
+
+	// the original is not put back in place of the remuxed file
+	if r, err := ResolveSwap(src, false, false); err != nil || r != ResolvedKept {
+		t.Errorf("got resolution %s, error %v", r, err)
+	}
+
+	// an existing file is not overwritten
+	if err := os.WriteFile(src, []byte("avi"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	entry.Dst = ""
+	entry.Args = []string{"-i", src, TempPath(src)}
+	if err := Execute(context.Background(), e, entry, false, false); err == nil {
+		t.Error("expected error of existing output")
+	}
+}
@@ -0,0 +1,72 @@
+// Package dupfinder searches for duplicated audio streams in media files.
+package dupfinder
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+
+	"github.com/hranicka/mediatool/internal"
+)
+
+// Processor reports audio streams which look the same as another stream of
+// the file.
+type Processor struct {
+	Exec internal.Executor
+}
+
+func (p *Processor) Process(ctx context.Context, path string) error {
+	slog.DebugContext(ctx, "opening file", "path", path)
+
+	f, err := internal.Probe(ctx, p.Exec, path)
+	if err != nil {
+		return fmt.Errorf("cannot probe file: %v", err)
+	}
+
+	data := make(map[string]internal.Stream)
+	dups := make(map[string][]internal.Stream)
+	for _, s := range f.Streams {
+		if s.CodecType != internal.TypeAudio {
+			continue
+		}
+
+		m := md5.New()
+		m.Write([]byte(s.CodecType))
+		m.Write([]byte(s.CodecName))
+		m.Write([]byte(s.BitRate))
+		m.Write([]byte(strconv.Itoa(s.Channels)))
+		m.Write([]byte(s.Tags.Language))
+		hash := hex.EncodeToString(m.Sum(nil))
+
+		if d, ok := data[hash]; ok {
+			if len(dups[hash]) == 0 {
+				dups[hash] = append(dups[hash], d)
+			}
+			dups[hash] = append(dups[hash], s)
+			continue
+		}
+		data[hash] = s
+	}
+
+	var affected []internal.Stream
+	for _, d := range dups {
+		affected = append(affected, d...)
+	}
+	sort.Slice(affected, func(i, j int) bool {
+		return affected[i].Index < affected[j].Index
+	})
+
+	if len(dups) > 0 {
+		slog.InfoContext(ctx, "possibly duplicated tracks", "path", path, "streams", dups)
+		internal.ReportDecision(ctx, internal.DecisionReport, "possibly duplicated audio streams", affected)
+	} else {
+		internal.ReportDecision(ctx, internal.DecisionSkip, "no duplicated audio streams", nil)
+	}
+
+	slog.DebugContext(ctx, "file finished", "path", path)
+	return nil
+}
@@ -0,0 +1,179 @@
+// Package exectest provides utilities for testing code running external
+// commands through internal.Executor.
+package exectest
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hranicka/mediatool/internal"
+)
+
+var (
+	update = flag.Bool("update", false, "update golden files")
+)
+
+// Call is a single recorded command invocation.
+type Call struct {
+	Name string
+	Args []string
+}
+
+// Recorder is a fake internal.Executor which records all invocations and
+// returns canned outputs instead of running anything.
+type Recorder struct {
+	// Outputs maps command names to their standard output.
+	Outputs map[string][]byte
+	// Errors maps command names to errors returned by their invocation.
+	Errors map[string]error
+	// Touch creates the file given as the last argument of every streamed
+	// command, emulating ffmpeg writing its output.
+	Touch bool
+
+	mu    sync.Mutex
+	calls []Call
+}
+
+func (r *Recorder) Output(_ context.Context, name string, arg ...string) ([]byte, error) {
+	r.record(name, arg)
+	if err := r.Errors[name]; err != nil {
+		return nil, err
+	}
+	return r.Outputs[name], nil
+}
+
+func (r *Recorder) Stream(_ context.Context, fn func(r io.Reader) error, name string, arg ...string) error {
+	r.record(name, arg)
+	if err := r.Errors[name]; err != nil {
+		return err
+	}
+	if r.Touch && len(arg) > 0 {
+		if err := os.WriteFile(arg[len(arg)-1], nil, 0o644); err != nil {
+			return err
+		}
+	}
+	return fn(bytes.NewReader(r.Outputs[name]))
+}
+
+// Calls returns the recorded invocations of the named command, or of all
+// commands when name is empty.
+func (r *Recorder) Calls(name string) []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var calls []Call
+	for _, c := range r.calls {
+		if name == "" || c.Name == name {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+func (r *Recorder) record(name string, arg []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, Call{Name: name, Args: append([]string(nil), arg...)})
+}
+
+// Format renders calls in a stable, diff friendly form: one argument per line,
+// calls separated by an empty line. Occurrences of dir are replaced by $DIR.
+func Format(calls []Call, dir string) string {
+	var b strings.Builder
+	for i, c := range calls {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(c.Name + "\n")
+		for _, a := range c.Args {
+			if dir != "" {
+				a = strings.ReplaceAll(a, dir, "$DIR")
+			}
+			b.WriteString("\t" + a + "\n")
+		}
+	}
+	return b.String()
+}
+
+// Golden compares got with the content of the golden file, rewriting the file
+// instead when the tests run with -update.
+func Golden(t *testing.T, path string, got string) {
+	t.Helper()
+
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("cannot read golden file (run with -update to create it): %v", err)
+	}
+	if got != string(want) {
+		t.Errorf("%s mismatch:\n--- got\n%s--- want\n%s", path, got, want)
+	}
+}
+
+// Fixtures returns paths of all files matching pattern, failing the test when
+// there are none.
+func Fixtures(t *testing.T, pattern string) []string {
+	t.Helper()
+
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatalf("no fixtures match %s", pattern)
+	}
+	return paths
+}
+
+// ProcessFixture creates an empty MKV file named after the ffprobe output
+// fixture and calls process on it using a Recorder returning that output. It
+// returns the recorder and the temporary directory holding the file.
+// Verification of converted files is disabled, as the recorder cannot
+// produce a matching output, and so is the free space check, as fixtures
+// describe files much larger than the empty ones.
+func ProcessFixture(t *testing.T, fixture string, process func(e *Recorder, src string) error) (*Recorder, string) {
+	t.Helper()
+	return ProcessFixtureAs(t, fixture, ".mkv", process)
+}
+
+// ProcessFixtureAs is ProcessFixture with a media file of the extension ext.
+func ProcessFixtureAs(t *testing.T, fixture string, ext string, process func(e *Recorder, src string) error) (*Recorder, string) {
+	t.Helper()
+
+	verify, checkSpace := internal.VerifyOutput, internal.CheckSpace
+	internal.VerifyOutput, internal.CheckSpace = false, false
+	t.Cleanup(func() { internal.VerifyOutput, internal.CheckSpace = verify, checkSpace })
+
+	probe, err := os.ReadFile(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, strings.TrimSuffix(filepath.Base(fixture), ".json")+ext)
+	if err := os.WriteFile(src, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := &Recorder{Outputs: map[string][]byte{"ffprobe": probe}, Touch: true}
+	if err := process(rec, src); err != nil {
+		t.Fatal(err)
+	}
+	return rec, dir
+}
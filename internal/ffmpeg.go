@@ -1,5 +1,40 @@
 package internal
 
+import (
+	"context"
+	"io"
+	"log/slog"
+	"time"
+)
+
 var (
 	FFmpegPath = "ffmpeg"
 )
+
+// RunFFmpeg runs ffmpeg with the given arguments and reports its progress to
+// the function attached to ctx by WithProgress. Duration is the length of the
+// source media, it is used to compute the completion ratio and may be zero.
+func RunFFmpeg(ctx context.Context, e Executor, duration time.Duration, arg ...string) error {
+	args := append([]string{"-nostats", "-progress", "pipe:1"}, arg...)
+
+	reportCommand(ctx, FFmpegPath, args)
+
+	report := progressFunc(ctx)
+	started := time.Now()
+	var speed float64
+	err := e.Stream(ctx, func(r io.Reader) error {
+		err := ParseProgress(r, func(p Progress) {
+			p.Duration = duration
+			if p.Speed > 0 {
+				speed = p.Speed
+			}
+			report(p)
+		})
+		if err != nil {
+			slog.WarnContext(ctx, "cannot read ffmpeg progress", "error", err)
+		}
+		return nil
+	}, FFmpegPath, args...)
+	reportFFmpegTime(ctx, time.Since(started), speed)
+	return err
+}
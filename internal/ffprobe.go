@@ -1,9 +1,11 @@
 package internal
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"os"
 )
 
 const (
@@ -19,6 +21,8 @@ const (
 	CodecAC3 = "ac3"
 	// CodecEAC3 is an E-AC3 codec
 	CodecEAC3 = "eac3"
+	// CodecAAC is an AAC codec
+	CodecAAC = "aac"
 )
 
 const (
@@ -26,6 +30,8 @@ const (
 	TypeVideo = "video"
 	// CodecH264 is an H264 codec
 	CodecH264 = "h264"
+	// CodecHEVC is an HEVC codec
+	CodecHEVC = "hevc"
 )
 
 const (
@@ -59,17 +65,74 @@ type FFprobe struct {
 	Format  Format   `json:"format"`
 }
 
-func Probe(src string) (*FFprobe, error) {
-	out, err := RunCmd("ffprobe", "-v", "quiet", "-print_format", "json", "-show_format", "-show_streams", src)
+// Probe returns streams and format of the file. Results are served from the
+// probe cache when one is in use and the file did not change.
+func Probe(ctx context.Context, e Executor, src string) (*FFprobe, error) {
+	var info os.FileInfo
+	if probeCache != nil {
+		var err error
+		if info, err = os.Stat(src); err != nil {
+			return nil, fmt.Errorf("cannot open file: %v", err)
+		}
+		if f, ok := probeCache.Get(src, info); ok {
+			slog.DebugContext(ctx, "probe cache hit", "src", src)
+			reportProbe(ctx, f)
+			return f, nil
+		}
+	}
+
+	f, err := probe(ctx, e, src)
+	if err != nil {
+		return nil, err
+	}
+
+	if probeCache != nil {
+		probeCache.Put(src, info, f)
+	}
+	reportProbe(ctx, f)
+	return f, nil
+}
+
+// probe runs ffprobe on the file bypassing the probe cache.
+func probe(ctx context.Context, e Executor, src string) (*FFprobe, error) {
+	release, err := Acquire(ctx, Cheap)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	out, err := e.Output(ctx, "ffprobe", "-v", "quiet", "-print_format", "json", "-show_format", "-show_streams", src)
 	if err != nil {
 		return nil, fmt.Errorf("cannot open file: %v", err)
 	}
-	slog.Debug("probing file", "src", src, "output", string(out))
+	slog.DebugContext(ctx, "probing file", "src", src, "output", string(out))
 
 	f := &FFprobe{}
 	if err := json.Unmarshal(out, f); err != nil {
 		return nil, fmt.Errorf("cannot parse %s output: %v", "ffprobe", err)
 	}
-
 	return f, nil
 }
+
+// IndexStreams sets TypeIndex of every stream, i.e. its position among streams
+// of the same type as used by ffmpeg stream specifiers (e.g. a:1). It returns
+// the highest index of every stream type.
+func (f *FFprobe) IndexStreams() map[string]int {
+	cnt := make(map[string]int)
+	for i, s := range f.Streams {
+		if _, ok := cnt[s.CodecType]; ok {
+			cnt[s.CodecType]++
+		} else {
+			cnt[s.CodecType] = 0
+		}
+		f.Streams[i].TypeIndex = cnt[s.CodecType]
+	}
+	return cnt
+}
+
+// clone returns a deep copy, so callers may modify streams of a cached result.
+func (f *FFprobe) clone() *FFprobe {
+	c := *f
+	c.Streams = append([]Stream(nil), f.Streams...)
+	return &c
+}
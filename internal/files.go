@@ -1,65 +1,141 @@
 package internal
 
 import (
-	"bufio"
-	"fmt"
+	"bytes"
+	"context"
+	"errors"
+	"io"
 	"log/slog"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
 )
 
-var (
-	filePattern = regexp.MustCompile(`(?i)\.(mkv|mp4)$`)
-)
-
-func FileIgnoresWhenExist(path string) []string {
-	ignores, err := FileIgnores(path)
-	if err != nil {
-		return nil
+// RemoveTemp deletes a temporary conversion output, e.g. after ffmpeg failed
+// or was interrupted. A missing file is not an error.
+func RemoveTemp(path string) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Warn("cannot remove temporary file", "path", path, "error", err)
+		return
 	}
-	return ignores
+	slog.Debug("temporary file removed", "path", path)
 }
 
-func FileIgnores(path string) (ignores []string, err error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, fmt.Errorf("error opening file: %w", err)
+// File is a media file found by Collect.
+type File struct {
+	Path string
+	Info os.FileInfo
+}
+
+// Collect returns all media files in dir which are not ignored along with
+// paths which could not be read.
+func Collect(ctx context.Context, dir string, opts WalkOptions) ([]File, []error) {
+	var files []File
+	errs := Walk(ctx, dir, opts, func(path string, info os.FileInfo) {
+		files = append(files, File{Path: path, Info: info})
+	})
+	return files, errs
+}
+
+// CollectPaths returns media files of paths, which are files or directories
+// walked by Collect with options returned by opts. Files are returned in order
+// of the paths, a file found by multiple paths only once. Paths which could
+// not be read are logged and returned.
+func CollectPaths(ctx context.Context, paths []string, opts func(dir string) WalkOptions) ([]File, []error) {
+	var files []File
+	var errs []error
+	seen := map[string]bool{}
+	add := func(f File) {
+		key, err := filepath.Abs(f.Path)
+		if err != nil {
+			key = filepath.Clean(f.Path)
+		}
+		if seen[key] {
+			slog.Debug("skipping file given already", "path", f.Path)
+			return
+		}
+		seen[key] = true
+		files = append(files, f)
 	}
-	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		text := scanner.Text()
-		if text == "" {
+	for _, path := range paths {
+		if ctx.Err() != nil {
+			break
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			slog.Warn("cannot read path", "path", path, "error", err)
+			errs = append(errs, err)
 			continue
 		}
-		ignores = append(ignores, text)
+		if !info.IsDir() {
+			add(File{Path: path, Info: info})
+			continue
+		}
+		found, walkErrs := Collect(ctx, path, opts(path))
+		for _, f := range found {
+			add(f)
+		}
+		errs = append(errs, walkErrs...)
 	}
-	if err = scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading from file: %w", err)
+	return files, errs
+}
+
+// ReadPaths reads a list of paths, one per line, or separated by NUL
+// characters when there is any, e.g. from find -print0. Empty entries are
+// skipped.
+func ReadPaths(r io.Reader) ([]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
 	}
 
-	slog.Info("loaded global ignores", "path", path, "ignores", ignores)
-	return ignores, nil
+	sep := "\n"
+	if bytes.IndexByte(data, 0) >= 0 {
+		sep = "\x00"
+	}
+	var paths []string
+	for _, p := range strings.Split(string(data), sep) {
+		if sep == "\n" {
+			p = strings.TrimSuffix(p, "\r")
+		}
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths, nil
 }
 
-func Walk(dir string, ignores []string, fn func(path string, info os.FileInfo)) {
-	_ = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-		if !filePattern.MatchString(path) {
-			slog.Debug("skipping unmatched file name", "path", path)
-			return nil
-		}
+// TotalSize returns the sum of file sizes.
+func TotalSize(files []File) (size int64) {
+	for _, f := range files {
+		size += f.Info.Size()
+	}
+	return size
+}
 
-		for _, ignore := range ignores {
-			if strings.Contains(path, ignore) {
-				slog.Info("skipping ignored file", "path", path, "ignore", ignore)
-				return nil
-			}
-		}
+// isMedia reports whether path is a media file name and not a leftover of a
+// conversion.
+func isMedia(path string) bool {
+	if !isMediaName(path) {
+		slog.Debug("skipping unmatched file name", "path", path)
+		return false
+	}
+	if _, ok := LeftoverSource(path); ok {
+		slog.Warn("skipping conversion leftover, see recover command", "path", path)
+		return false
+	}
+	return true
+}
 
-		fn(path, info)
-		return nil
-	})
+// explainIgnore logs the rule which decided whether path is ignored and
+// returns the decision.
+func explainIgnore(path string, ignored bool, rule *IgnoreRule) bool {
+	switch {
+	case ignored:
+		slog.Info("skipping ignored path", "path", path, "rule", rule.Pattern, "source", rule.Source)
+	case rule != nil:
+		slog.Debug("path included by negated rule", "path", path, "rule", rule.Pattern, "source", rule.Source)
+	}
+	return ignored
 }
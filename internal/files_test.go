@@ -0,0 +1,59 @@
+package internal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReadPaths(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"a.mkv\nb c.mkv\r\n\nd/\n", []string{"a.mkv", "b c.mkv", "d/"}},
+		{"a.mkv\x00new\nline.mkv\x00", []string{"a.mkv", "new\nline.mkv"}},
+	}
+	for _, tt := range tests {
+		got, err := ReadPaths(strings.NewReader(tt.in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("ReadPaths(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCollectPaths(t *testing.T) {
+	dir := walkTree(t, "a.mkv", "s/b.mkv", "s/c.mkv", "s/t/d.mp4")
+	path := func(p string) string {
+		return filepath.Join(dir, filepath.FromSlash(p))
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+
+	paths := []string{path("s/c.mkv"), "s", path("s/t"), "a.mkv", path("missing.mkv")}
+	files, errs := CollectPaths(context.Background(), paths, func(string) WalkOptions { return WalkOptions{} })
+	var got []string
+	for _, f := range files {
+		got = append(got, filepath.ToSlash(f.Path))
+	}
+	want := []string{filepath.ToSlash(path("s/c.mkv")), "s/b.mkv", "s/t/d.mp4", "a.mkv"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got files %v, want %v", got, want)
+	}
+	if len(errs) != 1 {
+		t.Errorf("got errors %v, want the missing file", errs)
+	}
+}
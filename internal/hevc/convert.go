@@ -2,13 +2,11 @@
 package hevc
 
 import (
+	"context"
 	"fmt"
 	"github.com/hranicka/mediatool/internal"
 	"log/slog"
-	"os"
-	"path/filepath"
 	"strconv"
-	"strings"
 )
 
 const (
@@ -16,46 +14,78 @@ const (
 	EncQualityTypeQP   = "qp"
 )
 
-var (
-	VaapiDevice       = "/dev/dri/renderD128"
-	EncQualityPercent = 0.6
-	EncQualityPreset  = 20
-	EncQualityType    = EncQualityTypeAuto
-	EncBitrate        = 0
-)
+// Processor re-encodes H.264 video streams to HEVC using VAAPI.
+type Processor struct {
+	Exec        internal.Executor
+	VaapiDevice string
+	// EncQualityType selects between bitrate relative to the source
+	// (EncQualityTypeAuto) and a static quality preset (EncQualityTypeQP).
+	EncQualityType    string
+	EncQualityPercent float64
+	EncQualityPreset  int
+	// EncBitrate is a fixed bitrate in kbps, it takes precedence over the
+	// quality type when set.
+	EncBitrate int
+	// Extensions are the accepted file extensions, internal.MediaExtensions
+	// are used when empty.
+	Extensions []string
+	DryRun     bool
+	Del        bool
+}
 
-func Process(src string, dryRun bool, del bool) error {
+func (p *Processor) Process(ctx context.Context, src string) error {
 	// read file streams
-	slog.Debug("opening file", "path", src)
+	slog.DebugContext(ctx, "opening file", "path", src)
 
 	// check file format
-	extension := strings.ToLower(filepath.Ext(src))
-	if extension != ".mkv" && extension != ".mp4" {
-		return fmt.Errorf("unsupported file format: %s", src)
+	if err := internal.CheckExtension(src, p.Extensions); err != nil {
+		return err
 	}
 
 	// probe file
-	f, err := internal.Probe(src)
+	f, err := internal.Probe(ctx, p.Exec, src)
 	if err != nil {
 		return fmt.Errorf("cannot get file info: %v", err)
 	}
 
+	toConvert, err := p.Detect(ctx, src, f)
+	if err != nil {
+		return err
+	}
+	if len(toConvert) > 0 {
+		slog.InfoContext(ctx, "converting tracks", "file", src, "cnt", len(toConvert), "streams", toConvert)
+
+		err := internal.Execute(ctx, p.Exec, &internal.PlanEntry{
+			File:     src,
+			Streams:  toConvert,
+			Class:    internal.Expensive,
+			Duration: f.Format.Duration,
+			Args:     p.args(src, toConvert),
+			Output:   internal.ExpectStreams(f, true, nil, internal.StreamCodecs(toConvert, internal.CodecHEVC)),
+			Estimate: internal.EstimateOutputSize(f, toConvert, p.Bitrates(toConvert)),
+		}, p.DryRun, p.Del)
+		if err != nil {
+			return err
+		}
+	}
+
+	slog.DebugContext(ctx, "file finished", "file", src)
+	return nil
+}
+
+// Detect returns the video stream of the probed file which should be converted,
+// or nil when the file needs no conversion. Missing stream bitrate is estimated
+// from the file bitrate.
+func (p *Processor) Detect(ctx context.Context, src string, f *internal.FFprobe) ([]internal.Stream, error) {
 	fileBitrate, err := strconv.Atoi(f.Format.BitRate)
 	if err != nil {
-		return fmt.Errorf("cannot get file bitrate: %v", err)
+		return nil, fmt.Errorf("cannot get file bitrate: %v", err)
 	}
 
 	// add type-specific stream counters
-	cnt := make(map[string]int)
+	f.IndexStreams()
 	var bitrateSum int
-	for i, s := range f.Streams {
-		if _, ok := cnt[s.CodecType]; ok {
-			cnt[s.CodecType]++
-		} else {
-			cnt[s.CodecType] = 0
-		}
-		f.Streams[i].TypeIndex = cnt[s.CodecType]
-
+	for _, s := range f.Streams {
 		if s.BitRate != "" {
 			br, _ := strconv.Atoi(s.BitRate)
 			bitrateSum += br
@@ -82,67 +112,76 @@ func Process(src string, dryRun bool, del bool) error {
 
 	// convert if needed
 	if len(toConvert) == 0 {
-		slog.Debug("no conversion needed", "file", src)
+		slog.DebugContext(ctx, "no conversion needed", "file", src)
+		internal.ReportDecision(ctx, internal.DecisionSkip, "no H.264 video stream", nil)
+		return nil, nil
 	} else if len(toConvert) > 1 {
-		slog.Warn("multiple video streams detected, cannot convert", "file", src)
-	} else {
-		slog.Info("converting tracks", "file", src, "cnt", len(toConvert), "streams", toConvert)
-
-		if !dryRun {
-			dst := src + ".tmp" + extension
-			if err := convert(src, dst, toConvert); err != nil {
-				return fmt.Errorf("cannot convert file: %v", err)
-			}
-
-			old := src + ".old"
-			if err := os.Rename(src, old); err != nil {
-				return fmt.Errorf("cannot rename source file: %v", err)
-			}
-			if err := os.Rename(dst, src); err != nil {
-				return fmt.Errorf("cannot rename converted file: %v", err)
-			}
-
-			if del {
-				if err := os.Remove(old); err != nil {
-					return fmt.Errorf("cannot delete source file: %v", err)
-				}
-			}
+		slog.WarnContext(ctx, "multiple video streams detected, cannot convert", "file", src)
+		internal.ReportDecision(ctx, internal.DecisionSkip, "multiple video streams", nil)
+		return nil, nil
+	}
+	internal.ReportDecision(ctx, internal.DecisionProcess, "H.264 video stream", toConvert)
+	return toConvert, nil
+}
+
+// Bitrates returns output bitrates of the encoded streams in bits per second
+// for EstimateOutputSize. Streams encoded with a static quality are left out,
+// their size is not known in advance.
+func (p *Processor) Bitrates(streams []internal.Stream) map[int]int64 {
+	bitrates := make(map[int]int64, len(streams))
+	for _, s := range streams {
+		br, _ := strconv.Atoi(s.BitRate)
+		if p.EncBitrate > 0 {
+			bitrates[s.Index] = int64(p.EncBitrate) * 1000
+		} else if p.EncQualityType == EncQualityTypeAuto && br > 0 {
+			bitrates[s.Index] = int64(float64(br) / 1024 * p.EncQualityPercent * 1000)
 		}
 	}
+	return bitrates
+}
 
-	slog.Debug("file finished", "file", src)
-	return nil
+// InputArgs returns ffmpeg arguments preceding the input file.
+func (p *Processor) InputArgs() []string {
+	return []string{"-vaapi_device", p.VaapiDevice}
+}
+
+// FilterArgs returns ffmpeg arguments uploading frames to the GPU.
+func (p *Processor) FilterArgs() []string {
+	return []string{"-vf", "format=nv12,hwupload"}
 }
 
-func convert(src string, dst string, streams []internal.Stream) error {
+// StreamArgs returns ffmpeg arguments encoding the stream s to the output video
+// stream with the given index.
+func (p *Processor) StreamArgs(s internal.Stream, index int) []string {
+	args := []string{fmt.Sprintf("-c:v:%d", index), "hevc_vaapi"}
+
+	br, _ := strconv.Atoi(s.BitRate)
+	if p.EncBitrate > 0 {
+		args = append(args, fmt.Sprintf("-b:v:%d", index), fmt.Sprintf("%dk", p.EncBitrate))
+	} else if p.EncQualityType == EncQualityTypeAuto && br > 0 {
+		args = append(args, fmt.Sprintf("-b:v:%d", index), fmt.Sprintf("%.0fk", (float64(br)/1024)*p.EncQualityPercent))
+	} else {
+		args = append(args, "-qp", fmt.Sprintf("%d", p.EncQualityPreset))
+	}
+
+	return append(args, "-low_power", "1")
+}
+
+// args returns ffmpeg arguments re-encoding the streams.
+func (p *Processor) args(src string, streams []internal.Stream) []string {
 	var args []string
-	args = append(args, "-vaapi_device", VaapiDevice)
+	args = append(args, p.InputArgs()...)
 	args = append(args, "-i", src)
-	args = append(args, "-vf", "format=nv12,hwupload")
+	args = append(args, p.FilterArgs()...)
 	args = append(args, "-map", "0")
 
 	for _, s := range streams {
-		args = append(args, fmt.Sprintf("-c:v:%d", s.TypeIndex), "hevc_vaapi")
-
-		br, _ := strconv.Atoi(s.BitRate)
-		if EncBitrate > 0 {
-			args = append(args, fmt.Sprintf("-b:v:%d", s.TypeIndex), fmt.Sprintf("%dk", EncBitrate))
-		} else if EncQualityType == EncQualityTypeAuto && br > 0 {
-			args = append(args, fmt.Sprintf("-b:v:%d", s.TypeIndex), fmt.Sprintf("%.0fk", (float64(br)/1024)*EncQualityPercent))
-		} else {
-			args = append(args, "-qp", fmt.Sprintf("%d", EncQualityPreset))
-		}
-
-		args = append(args, "-low_power", "1")
+		args = append(args, p.StreamArgs(s, s.TypeIndex)...)
 	}
 
 	args = append(args, "-c:a", "copy")
 	args = append(args, "-c:s", "copy")
 	args = append(args, "-max_muxing_queue_size", "4096")
-	args = append(args, dst)
-
-	slog.Debug("running ffmpeg", "file", src, "cmd", fmt.Sprintf("%s %v\n", internal.FFmpegPath, strings.Join(args, " ")))
-
-	_, err := internal.RunCmd(internal.FFmpegPath, args...)
-	return err
+	args = append(args, internal.TempPath(src))
+	return args
 }
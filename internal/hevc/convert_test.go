@@ -0,0 +1,150 @@
+package hevc
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hranicka/mediatool/internal"
+	"github.com/hranicka/mediatool/internal/exectest"
+)
+
+func TestProcessGolden(t *testing.T) {
+	for _, fixture := range exectest.Fixtures(t, "../testdata/ffprobe/*.json") {
+		name := strings.TrimSuffix(filepath.Base(fixture), ".json")
+		t.Run(name, func(t *testing.T) {
+			rec, dir := exectest.ProcessFixture(t, fixture, func(e *exectest.Recorder, src string) error {
+				p := &Processor{
+					Exec:              e,
+					VaapiDevice:       "/dev/dri/renderD128",
+					EncQualityType:    EncQualityTypeAuto,
+					EncQualityPercent: 0.6,
+					EncQualityPreset:  20,
+				}
+				return p.Process(context.Background(), src)
+			})
+			got := exectest.Format(rec.Calls(internal.FFmpegPath), dir)
+			exectest.Golden(t, filepath.Join("testdata", name+".golden"), got)
+		})
+	}
+}
+
+func TestProcessMP4(t *testing.T) {
+	var src string
+	rec, dir := exectest.ProcessFixtureAs(t, "../testdata/ffprobe/mp4/movie_h264_dts.json", ".mp4", func(e *exectest.Recorder, path string) error {
+		src = path
+		p := &Processor{Exec: e, VaapiDevice: "/dev/dri/renderD128", EncQualityType: EncQualityTypeAuto, EncQualityPercent: 0.6}
+		return p.Process(context.Background(), path)
+	})
+
+	// streams copied from an MP4 fit into it, so the file is not remuxed
+	calls := rec.Calls(internal.FFmpegPath)
+	if len(calls) != 1 {
+		t.Fatalf("expected single conversion, got %v", calls)
+	}
+	args := calls[0].Args
+	if args[len(args)-1] != internal.TempPath(src) || slices.Contains(args, "-f") {
+		t.Errorf("unexpected output: %s", exectest.Format(calls, dir))
+	}
+	if _, err := os.Stat(src); err != nil {
+		t.Errorf("converted file not in place: %v", err)
+	}
+	if _, err := os.Stat(strings.TrimSuffix(src, ".mp4") + ".mkv"); err == nil {
+		t.Error("file remuxed into MKV")
+	}
+}
+
+func TestProcessEstimate(t *testing.T) {
+	var entries []*internal.PlanEntry
+	exectest.ProcessFixture(t, "../testdata/ffprobe/series_h264_cover.json", func(e *exectest.Recorder, src string) error {
+		path := filepath.Join(filepath.Dir(src), "plan.jsonl")
+		plan, err := internal.CreatePlan(path)
+		if err != nil {
+			return err
+		}
+		files, err := internal.StatFiles(src)
+		if err != nil {
+			return err
+		}
+		p := &Processor{Exec: e, EncQualityType: EncQualityTypeAuto, EncQualityPercent: 0.6}
+		runner := &internal.Runner{Jobs: 1, Plan: plan, Command: "hevc"}
+		runner.Run(context.Background(), context.Background(), files, p.Process)
+		if _, err := plan.Close(); err != nil {
+			return err
+		}
+		entries, err = internal.ReadPlan(path)
+		return err
+	})
+	if len(entries) != 1 {
+		t.Fatalf("expected single plan entry, got %v", entries)
+	}
+
+	// the video stream has no bitrate, the rest of the file bitrate is used
+	size, seconds, video := int64(1610612736), 2580.1, float64(4993000-448000-128000)
+	encoded := float64(int64(video / 1024 * 0.6 * 1000))
+	want := size - int64(video*seconds/8) + int64(encoded*seconds/8)
+	if got := entries[0].Estimate; got != want {
+		t.Errorf("expected estimate %d, got %d", want, got)
+	}
+}
+
+// fixtureExecutor returns the ffprobe output fixture of the probed file.
+type fixtureExecutor map[string][]byte
+
+func (e fixtureExecutor) Output(_ context.Context, _ string, arg ...string) ([]byte, error) {
+	return e[arg[len(arg)-1]], nil
+}
+
+func (e fixtureExecutor) Stream(context.Context, func(r io.Reader) error, string, ...string) error {
+	return nil
+}
+
+func TestOrderSavings(t *testing.T) {
+	dir := t.TempDir()
+	e := fixtureExecutor{}
+	var files []internal.File
+	for _, name := range []string{"movie_hevc_multilang", "series_h264_cover", "anime_eac3"} {
+		probe, err := os.ReadFile(filepath.Join("../testdata/ffprobe", name+".json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var f internal.FFprobe
+		if err := json.Unmarshal(probe, &f); err != nil {
+			t.Fatal(err)
+		}
+		size, _ := strconv.ParseInt(f.Format.Size, 10, 64)
+
+		// sparse files of the probed size
+		src := filepath.Join(dir, name+".mkv")
+		if err := os.WriteFile(src, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Truncate(src, size); err != nil {
+			t.Skipf("cannot create sparse file: %v", err)
+		}
+		e[src] = probe
+		found, err := internal.StatFiles(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, found...)
+	}
+
+	// the H.264 video of the series has no bitrate of its own, it saves the
+	// most nonetheless, the HEVC movie saves nothing
+	p := &Processor{Exec: e, EncQualityType: EncQualityTypeAuto, EncQualityPercent: 0.6}
+	var got []string
+	for _, f := range internal.SortFiles(context.Background(), files, internal.OrderSavings, 1, p.Process) {
+		got = append(got, strings.TrimSuffix(filepath.Base(f.Path), ".mkv"))
+	}
+	want := []string{"series_h264_cover", "anime_eac3", "movie_hevc_multilang"}
+	if !slices.Equal(got, want) {
+		t.Errorf("expected order %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,293 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IgnoreRule is a single gitignore pattern.
+type IgnoreRule struct {
+	// Pattern is the line as written.
+	Pattern string
+	// Source is where the rule comes from, e.g. the ignore file and line.
+	Source string
+	// Base is the directory patterns are relative to.
+	Base string
+
+	negate  bool
+	dirOnly bool
+	re      *regexp.Regexp
+}
+
+// ParseIgnoreRule compiles a gitignore pattern relative to base. Comments and
+// blank lines yield nil.
+func ParseIgnoreRule(pattern string, base string, source string) (*IgnoreRule, error) {
+	r := &IgnoreRule{Pattern: pattern, Source: source, Base: base}
+
+	p := strings.TrimRight(pattern, " \t\r")
+	if strings.HasSuffix(p, `\`) && strings.HasSuffix(pattern, " ") {
+		p += " "
+	}
+	if p == "" || strings.HasPrefix(p, "#") {
+		return nil, nil
+	}
+	if strings.HasPrefix(p, "!") {
+		r.negate = true
+		p = p[1:]
+	}
+	if strings.HasSuffix(p, "/") {
+		r.dirOnly = true
+		p = strings.TrimRight(p, "/")
+	}
+	if p == "" {
+		return nil, fmt.Errorf("invalid ignore pattern %q", pattern)
+	}
+
+	// a slash other than the trailing one anchors the pattern to base
+	anchored := strings.Contains(p, "/")
+	p = strings.TrimPrefix(p, "/")
+
+	var re strings.Builder
+	re.WriteString("^")
+	if !anchored {
+		re.WriteString("(?:.*/)?")
+	}
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		switch {
+		case strings.HasPrefix(p[i:], "**/") && (i == 0 || p[i-1] == '/'):
+			re.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(p[i:], "**") && i+2 == len(p) && (i == 0 || p[i-1] == '/'):
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(p[i+1:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid ignore pattern %q: unterminated [", pattern)
+			}
+			class := p[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(p):
+			i++
+			re.WriteString(regexp.QuoteMeta(p[i : i+1]))
+		default:
+			re.WriteString(regexp.QuoteMeta(p[i : i+1]))
+		}
+	}
+	re.WriteString("$")
+
+	var err error
+	if r.re, err = regexp.Compile(re.String()); err != nil {
+		return nil, fmt.Errorf("invalid ignore pattern %q: %v", pattern, err)
+	}
+	return r, nil
+}
+
+// match reports whether the rule matches the path, which must be within Base.
+func (r *IgnoreRule) match(path string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	rel, ok := relPath(r.Base, path)
+	return ok && r.re.MatchString(rel)
+}
+
+func (r *IgnoreRule) String() string {
+	return r.Source + ": " + r.Pattern
+}
+
+// Ignorer decides which paths in a tree are ignored by gitignore rules. Rules
+// are given explicitly or read from ignore files in every directory, which
+// apply below it. The last matching rule wins and rules of deeper directories
+// take precedence, a negated rule (!) includes the path again. Nothing below
+// an ignored directory can be included again.
+type Ignorer struct {
+	root  string
+	names []string
+	rules []*IgnoreRule
+
+	mu   sync.Mutex
+	dirs map[string]*dirRules
+}
+
+// ignoreRecheck is how often ignore files of a directory are checked for
+// changes.
+const ignoreRecheck = time.Second
+
+// dirRules are rules of ignore files in a directory with the modification
+// times of the files, so changed files are read again.
+type dirRules struct {
+	stamps  []time.Time
+	checked time.Time
+	rules   []*IgnoreRule
+}
+
+// NewIgnorer creates an Ignorer of the tree at root using ignore files with
+// the given names and patterns relative to root.
+func NewIgnorer(root string, names []string, patterns []string) (*Ignorer, error) {
+	ig := &Ignorer{root: filepath.Clean(root), names: names, dirs: map[string]*dirRules{}}
+	for _, p := range patterns {
+		r, err := ParseIgnoreRule(p, ig.root, "-ignore")
+		if err != nil {
+			return nil, err
+		}
+		if r != nil {
+			ig.rules = append(ig.rules, r)
+		}
+	}
+	return ig, nil
+}
+
+// Ignored reports whether the path is ignored by itself or by an ignored
+// parent directory, and the rule which decided it. A nil Ignorer ignores
+// nothing.
+func (ig *Ignorer) Ignored(path string, isDir bool) (bool, *IgnoreRule) {
+	if ig == nil {
+		return false, nil
+	}
+	path = filepath.Clean(path)
+	rel, ok := relPath(ig.root, path)
+	if !ok {
+		return false, nil
+	}
+
+	parts := strings.Split(rel, "/")
+	dir := ig.root
+	for i := range parts[:len(parts)-1] {
+		dir = filepath.Join(dir, parts[i])
+		if ignored, rule := ig.Match(dir, true); ignored {
+			return true, rule
+		}
+	}
+	return ig.Match(path, isDir)
+}
+
+// Match reports whether the path itself is ignored, without checking its
+// parent directories, and the last matching rule.
+func (ig *Ignorer) Match(path string, isDir bool) (bool, *IgnoreRule) {
+	if ig == nil {
+		return false, nil
+	}
+	var matched *IgnoreRule
+	for _, rules := range ig.rulesFor(filepath.Dir(path)) {
+		for _, r := range rules {
+			if r.match(path, isDir) {
+				matched = r
+			}
+		}
+	}
+	if matched == nil {
+		return false, nil
+	}
+	return !matched.negate, matched
+}
+
+// rulesFor returns rules applying to entries of dir, from the least to the
+// most specific.
+func (ig *Ignorer) rulesFor(dir string) [][]*IgnoreRule {
+	all := [][]*IgnoreRule{ig.rules}
+	dir = filepath.Clean(dir)
+	if dir != ig.root {
+		rel, ok := relPath(ig.root, dir)
+		if !ok {
+			return all
+		}
+		all = append(all, ig.dirRules(ig.root))
+		d := ig.root
+		for _, part := range strings.Split(rel, "/") {
+			d = filepath.Join(d, part)
+			all = append(all, ig.dirRules(d))
+		}
+		return all
+	}
+	return append(all, ig.dirRules(dir))
+}
+
+// dirRules returns rules of ignore files in dir, reading them when they
+// changed.
+func (ig *Ignorer) dirRules(dir string) []*IgnoreRule {
+	ig.mu.Lock()
+	defer ig.mu.Unlock()
+
+	cached, ok := ig.dirs[dir]
+	if ok && time.Since(cached.checked) < ignoreRecheck {
+		return cached.rules
+	}
+	stamps := make([]time.Time, len(ig.names))
+	for i, name := range ig.names {
+		if info, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			stamps[i] = info.ModTime()
+		}
+	}
+	if ok && slices.EqualFunc(cached.stamps, stamps, time.Time.Equal) {
+		cached.checked = time.Now()
+		return cached.rules
+	}
+
+	var rules []*IgnoreRule
+	for i, name := range ig.names {
+		if stamps[i].IsZero() {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		r, err := readIgnoreFile(path, dir)
+		if err != nil {
+			slog.Warn("cannot read ignore file", "path", path, "error", err)
+			continue
+		}
+		slog.Debug("loaded ignore file", "path", path, "rules", len(r))
+		rules = append(rules, r...)
+	}
+	ig.dirs[dir] = &dirRules{stamps: stamps, checked: time.Now(), rules: rules}
+	return rules
+}
+
+// relPath returns the slash separated path relative to base, reporting
+// whether the path is below base.
+func relPath(base string, path string) (string, bool) {
+	rel, err := filepath.Rel(base, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// readIgnoreFile parses rules of an ignore file relative to base.
+func readIgnoreFile(path string, base string) ([]*IgnoreRule, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var rules []*IgnoreRule
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		r, err := ParseIgnoreRule(scanner.Text(), base, fmt.Sprintf("%s:%d", path, n))
+		if err != nil {
+			slog.Warn("skipping invalid ignore rule", "path", path, "line", n, "error", err)
+			continue
+		}
+		if r != nil {
+			rules = append(rules, r)
+		}
+	}
+	return rules, scanner.Err()
+}
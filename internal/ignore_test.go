@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestIgnoreRule(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		isDir   bool
+		want    bool
+	}{
+		{"Extras", "Extras", true, true},
+		{"Extras", "Movies/Extras", true, true},
+		{"Extras", "Movies/Extraordinary.mkv", false, false},
+		{"*.mp4", "a/b/c.mp4", false, true},
+		{"*.mp4", "a/b/c.mkv", false, false},
+		{"/top.mkv", "top.mkv", false, true},
+		{"/top.mkv", "sub/top.mkv", false, false},
+		{"Movies/*.mkv", "Movies/a.mkv", false, true},
+		{"Movies/*.mkv", "Movies/sub/a.mkv", false, false},
+		{"Movies/*.mkv", "x/Movies/a.mkv", false, false},
+		{"**/Samples", "a/b/Samples", true, true},
+		{"Shows/**/s01e??.mkv", "Shows/s01e01.mkv", false, true},
+		{"Shows/**/s01e??.mkv", "Shows/Foo/Season 1/s01e02.mkv", false, true},
+		{"Shows/**", "Shows/Foo/a.mkv", false, true},
+		{"Trailers/", "Trailers", true, true},
+		{"Trailers/", "Trailers", false, false},
+		{"[ab]*.mkv", "b1.mkv", false, true},
+		{"[!ab]*.mkv", "b1.mkv", false, false},
+		{`\#1.mkv`, "#1.mkv", false, true},
+		{`a\*.mkv`, "ab.mkv", false, false},
+	}
+	for _, tt := range tests {
+		r, err := ParseIgnoreRule(tt.pattern, "/lib", "test")
+		if err != nil {
+			t.Fatalf("%q: %v", tt.pattern, err)
+		}
+		if got := r.match(filepath.Join("/lib", tt.path), tt.isDir); got != tt.want {
+			t.Errorf("%q matching %q: got %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+
+	for _, p := range []string{"", "   ", "# comment"} {
+		if r, err := ParseIgnoreRule(p, "/lib", "test"); r != nil || err != nil {
+			t.Errorf("%q: got rule %v, error %v", p, r, err)
+		}
+	}
+	if _, err := ParseIgnoreRule("[ab", "/lib", "test"); err == nil {
+		t.Error("expected error of unterminated class")
+	}
+}
+
+func TestIgnorer(t *testing.T) {
+	dir := t.TempDir()
+	write := func(path string, content string) {
+		t.Helper()
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(".mediatool-ignore", "# extras are not worth it\nExtras/\n*-sample.mkv\n")
+	write(".ac3converter-ignore", "Anime/\n")
+	write("Shows/.mediatool-ignore", "*.mp4\n!keep.mp4\n")
+	write("Shows/Foo/.mediatool-ignore", "!*-sample.mkv\n")
+	for _, f := range []string{
+		"a.mkv", "Extraordinary.mkv", "Extras/bonus.mkv", "trailer-sample.mkv", "Anime/x.mkv", "Other/b.mp4",
+		"Shows/c.mp4", "Shows/keep.mp4", "Shows/Foo/d-sample.mkv", "Shows/Foo/e.mp4", "Shows/Extras/keep.mp4",
+	} {
+		write(f, f)
+	}
+
+	ig, err := NewIgnorer(dir, []string{".mediatool-ignore", ".ac3converter-ignore"}, []string{"/Other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	files, errs := Collect(context.Background(), dir, WalkOptions{Ignores: ig})
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	for _, f := range files {
+		rel, _ := filepath.Rel(dir, f.Path)
+		got = append(got, filepath.ToSlash(rel))
+	}
+	want := []string{"Extraordinary.mkv", "Shows/Foo/d-sample.mkv", "Shows/keep.mp4", "a.mkv"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got files %v, want %v", got, want)
+	}
+
+	// files within ignored directories are ignored too
+	ignored, rule := ig.Ignored(filepath.Join(dir, "Shows", "Extras", "keep.mp4"), false)
+	if !ignored || rule.Pattern != "Extras/" || rule.Source != filepath.Join(dir, ".mediatool-ignore")+":2" {
+		t.Errorf("got ignored %v by %v", ignored, rule)
+	}
+	if ignored, rule := ig.Ignored(filepath.Join(dir, "Shows", "keep.mp4"), false); ignored || rule.Pattern != "!keep.mp4" {
+		t.Errorf("got ignored %v by %v", ignored, rule)
+	}
+}
@@ -0,0 +1,269 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JobState is a state of a file in the batch journal.
+type JobState string
+
+const (
+	StateQueued     JobState = "queued"
+	StateProbing    JobState = "probing"
+	StateConverting JobState = "converting"
+	StateSwapping   JobState = "swapping"
+	StateDone       JobState = "done"
+	StateFailed     JobState = "failed"
+)
+
+// Journal is an append-only log of file states of a batch run. It allows an
+// interrupted batch to be resumed and half-done conversions to be resolved.
+type Journal struct {
+	path string
+
+	mu      sync.Mutex
+	f       *os.File
+	order   []string
+	entries map[string]JournalEntry
+}
+
+// JournalEntry is a single state transition of a file.
+type JournalEntry struct {
+	Path  string    `json:"path"`
+	State JobState  `json:"state"`
+	Time  time.Time `json:"time"`
+	Error string    `json:"error,omitempty"`
+}
+
+// TempPath returns the path of the conversion output of src.
+func TempPath(src string) string {
+	return src + ".tmp" + strings.ToLower(filepath.Ext(src))
+}
+
+// OldPath returns the path the original file is kept at after conversion.
+func OldPath(src string) string {
+	return src + ".old"
+}
+
+// OpenJournal reads the journal at path, creating it when it does not exist.
+func OpenJournal(path string) (*Journal, error) {
+	j := &Journal{path: path, entries: make(map[string]JournalEntry)}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open journal: %w", err)
+	}
+	j.f = f
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// the last line may be truncated by a crash
+			slog.Warn("skipping invalid journal record", "path", path, "error", err)
+			continue
+		}
+		if _, ok := j.entries[e.Path]; !ok {
+			j.order = append(j.order, e.Path)
+		}
+		j.entries[e.Path] = e
+	}
+	if err := scanner.Err(); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("cannot read journal: %w", err)
+	}
+
+	return j, nil
+}
+
+// StartJournal opens the batch journal at path and resolves files left
+// half-done by a previous run. With resume set, unfinished files of the
+// previous batch are returned, otherwise (or if there are none) a new batch of
+// collected files is started. The journal is nil when it cannot be used, the
+// batch then runs without it.
+func StartJournal(path string, resume bool, collect func() []File) (*Journal, []File) {
+	j, err := OpenJournal(path)
+	if err != nil {
+		slog.Warn("cannot use journal", "path", path, "error", err)
+		return nil, collect()
+	}
+	j.Recover()
+
+	if pending := j.Pending(); resume && len(pending) > 0 {
+		var files []File
+		for _, p := range pending {
+			f, err := StatFiles(p)
+			if err != nil {
+				slog.Warn("skipping journaled file", "file", p, "error", err)
+				continue
+			}
+			files = append(files, f...)
+		}
+		slog.Info("resuming previous batch", "journal", path, "files", len(files))
+		return j, files
+	} else if len(pending) > 0 {
+		slog.Info("discarding unfinished batch, use -resume to continue it", "journal", path, "files", len(pending))
+	}
+
+	files := collect()
+	if err := j.Start(files); err != nil {
+		slog.Warn("cannot use journal", "path", path, "error", err)
+		_ = j.Close(false)
+		return nil, files
+	}
+	return j, files
+}
+
+// Pending returns files of the journaled batch which are not finished yet, in
+// the original order.
+func (j *Journal) Pending() []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var pending []string
+	for _, path := range j.order {
+		if s := j.entries[path].State; s != StateDone && s != StateFailed {
+			pending = append(pending, path)
+		}
+	}
+	return pending
+}
+
+// Recover resolves files which were being converted or swapped when the
+// previous run stopped, see ResolveSwap. A file whose swap got finished, now
+// or before the run stopped, is done, the others stay queued.
+func (j *Journal) Recover() {
+	j.mu.Lock()
+	entries := make([]JournalEntry, 0, len(j.order))
+	for _, path := range j.order {
+		entries = append(entries, j.entries[path])
+	}
+	j.mu.Unlock()
+
+	for _, e := range entries {
+		if e.State != StateProbing && e.State != StateConverting && e.State != StateSwapping {
+			continue
+		}
+
+		res, err := ResolveSwap(e.Path, false, false)
+		if err != nil {
+			slog.Error("cannot recover interrupted conversion", "file", e.Path, "error", err)
+			j.Set(e.Path, StateFailed, err)
+			continue
+		}
+		if res != ResolvedNone && res != ResolvedKept {
+			slog.Info("recovered interrupted conversion", "file", e.Path, "resolution", res)
+		}
+		// without an intent and a temporary file, a swapping file was swapped
+		// already and only its state was not recorded
+		swapped := e.State == StateSwapping && (res == ResolvedNone || res == ResolvedKept) && (exists(e.Path) || remuxed(e.Path))
+		if res == ResolvedCompleted || swapped {
+			j.Set(e.Path, StateDone, nil)
+		} else {
+			j.Set(e.Path, StateQueued, nil)
+		}
+	}
+}
+
+// Start truncates the journal and records files of a new batch as queued.
+func (j *Journal) Start(files []File) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.f.Truncate(0); err != nil {
+		return fmt.Errorf("cannot reset journal: %w", err)
+	}
+	j.order = nil
+	j.entries = make(map[string]JournalEntry)
+
+	for _, f := range files {
+		if err := j.append(f.Path, StateQueued, nil); err != nil {
+			return err
+		}
+	}
+	return j.f.Sync()
+}
+
+// Set records a new state of the file. Failures are logged only, since losing
+// the journal must not stop the conversion itself.
+func (j *Journal) Set(path string, state JobState, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.append(path, state, err); err != nil {
+		slog.Warn("cannot write journal", "path", j.path, "error", err)
+		return
+	}
+	if err := j.f.Sync(); err != nil {
+		slog.Warn("cannot write journal", "path", j.path, "error", err)
+	}
+}
+
+// Close closes the journal and removes it if the batch was finished.
+func (j *Journal) Close(finished bool) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.f.Close(); err != nil {
+		return err
+	}
+	if finished {
+		return os.Remove(j.path)
+	}
+	return nil
+}
+
+// append writes a record, must be called with j.mu held.
+func (j *Journal) append(path string, state JobState, err error) error {
+	e := JournalEntry{Path: path, State: state, Time: time.Now()}
+	if err != nil {
+		e.Error = err.Error()
+	}
+
+	data, mErr := json.Marshal(e)
+	if mErr != nil {
+		return mErr
+	}
+	if _, wErr := j.f.Write(append(data, '\n')); wErr != nil {
+		return wErr
+	}
+
+	if _, ok := j.entries[path]; !ok {
+		j.order = append(j.order, path)
+	}
+	j.entries[path] = e
+	return nil
+}
+
+type jobKey struct{}
+
+type job struct {
+	journal *Journal
+	path    string
+}
+
+func withJob(ctx context.Context, j *Journal, path string) context.Context {
+	return context.WithValue(ctx, jobKey{}, job{journal: j, path: path})
+}
+
+// SetJobState records the state of the file processed with ctx in the batch
+// journal. It does nothing when the batch is not journaled.
+func SetJobState(ctx context.Context, state JobState) {
+	if j, ok := ctx.Value(jobKey{}).(job); ok {
+		j.journal.Set(j.path, state, nil)
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJournalResume(t *testing.T) {
+	dir := t.TempDir()
+	var files []File
+	for _, name := range []string{"a.mkv", "b.mkv", "c.mkv", "d.mkv"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, File{Path: path})
+	}
+
+	path := filepath.Join(dir, ".journal")
+	j, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Start(files); err != nil {
+		t.Fatal(err)
+	}
+	j.Set(files[0].Path, StateDone, nil)
+	j.Set(files[1].Path, StateFailed, errors.New("broken"))
+	j.Set(files[2].Path, StateConverting, nil)
+	if err := j.Close(false); err != nil {
+		t.Fatal(err)
+	}
+
+	// leftover of the interrupted conversion
+	if err := os.WriteFile(TempPath(files[2].Path), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	j, files2 := StartJournal(path, true, func() []File {
+		t.Fatal("unexpected collect on resume")
+		return nil
+	})
+	defer j.Close(true)
+
+	if len(files2) != 2 || files2[0].Path != files[2].Path || files2[1].Path != files[3].Path {
+		t.Fatalf("unexpected resumed files: %v", files2)
+	}
+	if exists(TempPath(files[2].Path)) {
+		t.Error("unfinished conversion not removed")
+	}
+}
+
+func TestJournalRecoverSwapped(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "a.mkv")
+	// the swap finished, keeping the original, before the state was recorded
+	for _, path := range []string{src, OldPath(src)} {
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path := filepath.Join(dir, ".journal")
+	j, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Start([]File{{Path: src}}); err != nil {
+		t.Fatal(err)
+	}
+	j.Set(src, StateSwapping, nil)
+	j.Recover()
+	if pending := j.Pending(); len(pending) > 0 {
+		t.Errorf("swapped file pending: %v", pending)
+	}
+	_ = j.Close(true)
+}
@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+)
+
+// Class describes how demanding an operation is, every class has its own
+// concurrency limit shared by all workers.
+type Class int
+
+const (
+	// Cheap operations are mostly I/O bound, e.g. probing or stream copy remux.
+	Cheap Class = iota
+	// Expensive operations are CPU or GPU bound, e.g. audio or video encoding.
+	Expensive
+)
+
+func (c Class) String() string {
+	switch c {
+	case Cheap:
+		return "cheap"
+	case Expensive:
+		return "expensive"
+	}
+	return fmt.Sprintf("class(%d)", int(c))
+}
+
+func (c Class) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+func (c *Class) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "cheap":
+		*c = Cheap
+	case "expensive":
+		*c = Expensive
+	default:
+		return fmt.Errorf("unknown class %q", text)
+	}
+	return nil
+}
+
+var (
+	limits = map[Class]chan struct{}{}
+)
+
+// SetLimits configures concurrency limits of operation classes. A zero limit
+// falls back to jobs, a negative one means unlimited. Must be called before
+// any processing starts.
+func SetLimits(jobs int, cheap int, expensive int) {
+	for class, n := range map[Class]int{Cheap: cheap, Expensive: expensive} {
+		if n == 0 {
+			n = jobs
+		}
+		if n <= 0 {
+			delete(limits, class)
+			continue
+		}
+		limits[class] = make(chan struct{}, n)
+	}
+}
+
+// Acquire blocks until an operation of the class may run. The returned release
+// function must be called once the operation is finished.
+func Acquire(ctx context.Context, class Class) (release func(), err error) {
+	sem, ok := limits[class]
+	if !ok {
+		return func() {}, nil
+	}
+
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+var (
+	logLevel = new(slog.LevelVar)
+	stderr   = &statusLine{w: os.Stderr}
+)
+
+// SetupLogging installs the default logger. Records logged with a context
+// returned by withLogBuffer are held back until the buffer is flushed.
+func SetupLogging(verbose bool) {
+	if verbose {
+		logLevel.Set(slog.LevelDebug)
+	}
+	h := slog.NewTextHandler(stderr, &slog.HandlerOptions{Level: logLevel})
+	slog.SetDefault(slog.New(&bufferingHandler{Handler: h}))
+}
+
+type logBufferKey struct{}
+
+type bufferedRecord struct {
+	h slog.Handler
+	r slog.Record
+}
+
+// logBuffer collects log records of a single file processed concurrently with
+// others, so they can be emitted in a deterministic order.
+type logBuffer struct {
+	mu      sync.Mutex
+	records []bufferedRecord
+}
+
+func withLogBuffer(ctx context.Context, buf *logBuffer) context.Context {
+	return context.WithValue(ctx, logBufferKey{}, buf)
+}
+
+func (b *logBuffer) flush() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, br := range b.records {
+		_ = br.h.Handle(context.Background(), br.r)
+	}
+	b.records = nil
+}
+
+type bufferingHandler struct {
+	slog.Handler
+}
+
+func (h *bufferingHandler) Handle(ctx context.Context, r slog.Record) error {
+	if buf, ok := ctx.Value(logBufferKey{}).(*logBuffer); ok {
+		buf.mu.Lock()
+		buf.records = append(buf.records, bufferedRecord{h: h.Handler, r: r.Clone()})
+		buf.mu.Unlock()
+		return nil
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *bufferingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &bufferingHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *bufferingHandler) WithGroup(name string) slog.Handler {
+	return &bufferingHandler{Handler: h.Handler.WithGroup(name)}
+}
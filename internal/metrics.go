@@ -0,0 +1,225 @@
+package internal
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// FFmpegTimeBuckets are upper bounds of the ffmpeg wall time histogram in
+	// seconds.
+	FFmpegTimeBuckets = []float64{1, 5, 15, 30, 60, 120, 300, 600, 1200, 1800, 3600, 7200}
+	// SpeedBuckets are upper bounds of the encoding speed histogram, relative
+	// to real time.
+	SpeedBuckets = []float64{0.25, 0.5, 1, 2, 4, 8, 16, 32, 64}
+)
+
+// Metrics aggregates results of processed files per processor and exposes
+// them in the Prometheus text format.
+type Metrics struct {
+	mu         sync.Mutex
+	processors map[string]*processorMetrics
+}
+
+type processorMetrics struct {
+	scanned    int64
+	converted  int64
+	skipped    int64
+	failed     int64
+	bytesSaved int64
+	ffmpegTime *histogram
+	speed      *histogram
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{processors: map[string]*processorMetrics{}}
+}
+
+// Observe counts the finished file described by the report entry. Files
+// processed in dry mode count as skipped.
+func (m *Metrics) Observe(e *ReportEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p := m.processors[e.Command]
+	if p == nil {
+		p = &processorMetrics{ffmpegTime: newHistogram(FFmpegTimeBuckets), speed: newHistogram(SpeedBuckets)}
+		m.processors[e.Command] = p
+	}
+
+	p.scanned++
+	switch {
+	case e.Error != "":
+		p.failed++
+	case e.Decision == DecisionProcess && !e.DryRun:
+		p.converted++
+		p.bytesSaved += e.SizeBefore - e.SizeAfter
+	default:
+		p.skipped++
+	}
+	if e.FFmpegTime > 0 {
+		p.ffmpegTime.observe(e.FFmpegTime)
+	}
+	if e.Speed > 0 {
+		p.speed.observe(e.Speed)
+	}
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.processors))
+	for name := range m.processors {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	mw := &metricsWriter{w: bufio.NewWriter(w)}
+	counter := func(name string, help string, value func(p *processorMetrics) int64) {
+		mw.header(name, "counter", help)
+		for _, n := range names {
+			mw.sample(name, n, "", formatInt(value(m.processors[n])))
+		}
+	}
+	counter("mediatool_files_scanned_total", "Files inspected by the processor.", func(p *processorMetrics) int64 { return p.scanned })
+	counter("mediatool_files_converted_total", "Files converted by the processor.", func(p *processorMetrics) int64 { return p.converted })
+	counter("mediatool_files_skipped_total", "Files which did not need a conversion.", func(p *processorMetrics) int64 { return p.skipped })
+	counter("mediatool_files_failed_total", "Files which could not be processed.", func(p *processorMetrics) int64 { return p.failed })
+
+	mw.header("mediatool_bytes_saved", "gauge", "Size difference of original and converted files.")
+	for _, n := range names {
+		mw.sample("mediatool_bytes_saved", n, "", formatInt(m.processors[n].bytesSaved))
+	}
+
+	hist := func(name string, help string, h func(p *processorMetrics) *histogram) {
+		mw.header(name, "histogram", help)
+		for _, n := range names {
+			h(m.processors[n]).write(mw, name, n)
+		}
+	}
+	hist("mediatool_ffmpeg_duration_seconds", "Wall time of ffmpeg runs per file.", func(p *processorMetrics) *histogram { return p.ffmpegTime })
+	hist("mediatool_encode_speed_ratio", "Encoding speed relative to real time.", func(p *processorMetrics) *histogram { return p.speed })
+
+	return mw.n, mw.flush()
+}
+
+// ServeHTTP serves the metrics to a Prometheus scraper.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if _, err := m.WriteTo(w); err != nil {
+		slog.Debug("cannot write metrics", "error", err)
+	}
+}
+
+// WriteFile writes the metrics for the textfile collector of node_exporter.
+// The file is replaced atomically, so it is never read half-written.
+func (m *Metrics) WriteFile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = m.WriteTo(tmp)
+	if err == nil {
+		err = tmp.Chmod(0o644)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// histogram counts observations in cumulative buckets.
+type histogram struct {
+	bounds []float64
+	counts []int64
+	count  int64
+	sum    float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]int64, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, b := range h.bounds {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *histogram) write(mw *metricsWriter, name string, processor string) {
+	for i, b := range h.bounds {
+		mw.sample(name+"_bucket", processor, formatFloat(b), formatInt(h.counts[i]))
+	}
+	mw.sample(name+"_bucket", processor, "+Inf", formatInt(h.count))
+	mw.sample(name+"_sum", processor, "", formatFloat(h.sum))
+	mw.sample(name+"_count", processor, "", formatInt(h.count))
+}
+
+// metricsWriter writes lines of the text format, remembering the first error.
+type metricsWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (mw *metricsWriter) header(name string, typ string, help string) {
+	mw.line("# HELP " + name + " " + help)
+	mw.line("# TYPE " + name + " " + typ)
+}
+
+func (mw *metricsWriter) sample(name string, processor string, le string, value string) {
+	labels := `processor="` + escapeLabel(processor) + `"`
+	if le != "" {
+		labels += `,le="` + le + `"`
+	}
+	mw.line(name + "{" + labels + "} " + value)
+}
+
+func (mw *metricsWriter) line(s string) {
+	if mw.err != nil {
+		return
+	}
+	n, err := mw.w.WriteString(s + "\n")
+	mw.n += int64(n)
+	mw.err = err
+}
+
+func (mw *metricsWriter) flush() error {
+	if mw.err != nil {
+		return mw.err
+	}
+	return mw.w.Flush()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatInt(v int64) string {
+	return strconv.FormatInt(v, 10)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunnerMetrics(t *testing.T) {
+	dir := t.TempDir()
+	var files []File
+	for _, name := range []string{"a.mkv", "b.mkv", "c.mkv"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("original"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		info, _ := os.Stat(path)
+		files = append(files, File{Path: path, Info: info})
+	}
+
+	metrics := NewMetrics()
+	runner := &Runner{Jobs: 2, Command: "test", Metrics: metrics}
+	runner.Run(context.Background(), context.Background(), files, func(ctx context.Context, path string) error {
+		switch filepath.Base(path) {
+		case "a.mkv":
+			ReportDecision(ctx, DecisionProcess, "needed", nil)
+			reportFFmpegTime(ctx, 90*time.Second, 3)
+			return os.WriteFile(path, []byte("new"), 0o644)
+		case "b.mkv":
+			ReportDecision(ctx, DecisionSkip, "not needed", nil)
+			return nil
+		}
+		return errors.New("broken")
+	})
+	metrics.Observe(&ReportEntry{Command: `a"b`, Decision: DecisionProcess, DryRun: true})
+
+	path := filepath.Join(dir, "mediatool.prom")
+	if err := metrics.WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(b)
+
+	for _, line := range []string{
+		"# TYPE mediatool_files_scanned_total counter",
+		`mediatool_files_scanned_total{processor="test"} 3`,
+		`mediatool_files_converted_total{processor="test"} 1`,
+		`mediatool_files_skipped_total{processor="test"} 1`,
+		`mediatool_files_failed_total{processor="test"} 1`,
+		`mediatool_files_skipped_total{processor="a\"b"} 1`,
+		`mediatool_bytes_saved{processor="test"} 5`,
+		"# TYPE mediatool_ffmpeg_duration_seconds histogram",
+		`mediatool_ffmpeg_duration_seconds_bucket{processor="test",le="60"} 0`,
+		`mediatool_ffmpeg_duration_seconds_bucket{processor="test",le="120"} 1`,
+		`mediatool_ffmpeg_duration_seconds_bucket{processor="test",le="+Inf"} 1`,
+		`mediatool_ffmpeg_duration_seconds_sum{processor="test"} 90`,
+		`mediatool_encode_speed_ratio_bucket{processor="test",le="2"} 0`,
+		`mediatool_encode_speed_ratio_bucket{processor="test",le="4"} 1`,
+		`mediatool_encode_speed_ratio_count{processor="a\"b"} 0`,
+	} {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, got)
+		}
+	}
+}
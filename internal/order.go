@@ -0,0 +1,154 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Order selects the order files of a batch are processed in.
+type Order string
+
+const (
+	// OrderWalk keeps files in the order they were found.
+	OrderWalk Order = ""
+	// OrderLargest processes the largest files first.
+	OrderLargest Order = "largest"
+	// OrderOldest processes the least recently modified files first.
+	OrderOldest Order = "oldest"
+	// OrderSavings processes files with the highest estimated savings first.
+	OrderSavings Order = "savings"
+	// OrderRandom shuffles files.
+	OrderRandom Order = "random"
+)
+
+// ParseOrder parses an order name, an empty name keeps the walk order.
+func ParseOrder(s string) (Order, error) {
+	switch o := Order(s); o {
+	case OrderWalk, OrderLargest, OrderOldest, OrderSavings, OrderRandom:
+		return o, nil
+	}
+	return OrderWalk, fmt.Errorf("unknown order %q", s)
+}
+
+// Budget limits the work of a batch. Once a limit is reached no new file is
+// started, running files are finished. Zero values mean unlimited.
+type Budget struct {
+	// MaxFiles is the number of started files.
+	MaxFiles int
+	// MaxBytes is the total size of started files, files which do not fit are
+	// postponed while smaller ones may still start.
+	MaxBytes int64
+	// Deadline is the time after which no file is started.
+	Deadline time.Time
+}
+
+// admit reports why a file of the size cannot start after started files of
+// total size, or an empty string when it can. Exhausted budgets postpone all
+// remaining files.
+func (b Budget) admit(started int, total int64, size int64) (reason string, exhausted bool) {
+	switch {
+	case !b.Deadline.IsZero() && !time.Now().Before(b.Deadline):
+		return "deadline passed", true
+	case b.MaxFiles > 0 && started >= b.MaxFiles:
+		return "max files reached", true
+	case b.MaxBytes > 0 && total >= b.MaxBytes:
+		return "max bytes reached", true
+	case b.MaxBytes > 0 && total+size > b.MaxBytes:
+		return "max bytes would be exceeded", false
+	}
+	return "", false
+}
+
+// SortFiles returns files in the order. Savings of OrderSavings are
+// estimated by EstimateSavings using up to jobs concurrent workers until ctx
+// is done, files not estimated by then save nothing.
+func SortFiles(ctx context.Context, files []File, order Order, jobs int, fn func(ctx context.Context, path string) error) []File {
+	files = slices.Clone(files)
+	switch order {
+	case OrderLargest:
+		slices.SortStableFunc(files, func(a, b File) int {
+			return compare(b.Info.Size(), a.Info.Size())
+		})
+	case OrderOldest:
+		slices.SortStableFunc(files, func(a, b File) int {
+			return a.Info.ModTime().Compare(b.Info.ModTime())
+		})
+	case OrderSavings:
+		savings := estimateAll(ctx, files, jobs, fn)
+		idx := make(map[string]int64, len(files))
+		for i, f := range files {
+			idx[f.Path] = savings[i]
+		}
+		slices.SortStableFunc(files, func(a, b File) int {
+			return compare(idx[b.Path], idx[a.Path])
+		})
+	case OrderRandom:
+		rand.Shuffle(len(files), func(i, j int) {
+			files[i], files[j] = files[j], files[i]
+		})
+	}
+	return files
+}
+
+func compare(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// estimateAll returns estimated savings of files, files which cannot be
+// estimated save nothing.
+func estimateAll(ctx context.Context, files []File, jobs int, fn func(ctx context.Context, path string) error) []int64 {
+	slog.Info("estimating savings", "files", len(files))
+	savings := make([]int64, len(files))
+
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for range max(min(jobs, len(files)), 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				saved, err := EstimateSavings(ctx, files[i], fn)
+				if err != nil {
+					slog.Debug("cannot estimate savings", "file", files[i].Path, "error", err)
+				}
+				savings[i] = saved
+			}
+		}()
+	}
+	for i := range files {
+		if ctx.Err() != nil {
+			slog.Warn("savings estimation stopped, files left are processed last", "estimated", i, "files", len(files))
+			break
+		}
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+	return savings
+}
+
+// EstimateSavings returns the number of bytes the conversion of the file by fn
+// is expected to save. fn runs in plan mode, so nothing is converted, and its
+// logs are dropped. A file which needs no conversion saves nothing.
+func EstimateSavings(ctx context.Context, f File, fn func(ctx context.Context, path string) error) (int64, error) {
+	rec := &planRecord{}
+	ctx = withLogBuffer(withPlanRecord(ctx, rec), &logBuffer{})
+	if err := fn(ctx, f.Path); err != nil {
+		return 0, err
+	}
+	if rec.entry == nil {
+		return 0, nil
+	}
+	return f.Info.Size() - rec.entry.Estimate, nil
+}
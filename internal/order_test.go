@@ -0,0 +1,148 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// orderFiles creates files of the sizes, each modified an hour before the
+// previous one.
+func orderFiles(t *testing.T, sizes ...int) []File {
+	t.Helper()
+	dir := t.TempDir()
+	now := time.Now()
+	var files []File
+	for i, size := range sizes {
+		path := filepath.Join(dir, string(rune('a'+i))+".mkv")
+		if err := os.WriteFile(path, make([]byte, size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := now.Add(-time.Duration(i) * time.Hour)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		f, err := StatFiles(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, f...)
+	}
+	return files
+}
+
+func names(files []File) []string {
+	var names []string
+	for _, f := range files {
+		names = append(names, filepath.Base(f.Path))
+	}
+	return names
+}
+
+func TestSortFiles(t *testing.T) {
+	files := orderFiles(t, 10, 30, 20)
+	// the plan of every file expects it to shrink to 5 bytes, except b
+	estimate := func(ctx context.Context, path string) error {
+		if filepath.Base(path) == "b.mkv" {
+			return nil
+		}
+		return Execute(ctx, nil, &PlanEntry{File: path, Estimate: 5}, false, false)
+	}
+
+	tests := []struct {
+		order Order
+		want  []string
+	}{
+		{OrderWalk, []string{"a.mkv", "b.mkv", "c.mkv"}},
+		{OrderLargest, []string{"b.mkv", "c.mkv", "a.mkv"}},
+		{OrderOldest, []string{"c.mkv", "b.mkv", "a.mkv"}},
+		{OrderSavings, []string{"c.mkv", "a.mkv", "b.mkv"}},
+	}
+	for _, tt := range tests {
+		got := names(SortFiles(context.Background(), files, tt.order, 2, estimate))
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.order, got, tt.want)
+		}
+	}
+	if got := names(files); !slices.Equal(got, tests[0].want) {
+		t.Errorf("files were reordered in place: %v", got)
+	}
+
+	// files are not estimated once the context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if got := names(SortFiles(ctx, files, OrderSavings, 2, estimate)); !slices.Equal(got, tests[0].want) {
+		t.Errorf("cancelled savings estimation: got %v", got)
+	}
+}
+
+func TestRunnerBudget(t *testing.T) {
+	files := orderFiles(t, 10, 30, 20, 5)
+	tests := []struct {
+		name   string
+		budget Budget
+		want   []string
+	}{
+		{"unlimited", Budget{}, []string{"a.mkv", "b.mkv", "c.mkv", "d.mkv"}},
+		{"max files", Budget{MaxFiles: 2}, []string{"a.mkv", "b.mkv"}},
+		{"max bytes", Budget{MaxBytes: 35}, []string{"a.mkv", "c.mkv", "d.mkv"}},
+		{"deadline", Budget{Deadline: time.Now().Add(-time.Second)}, nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		r := &Runner{Jobs: 1, Budget: tt.budget}
+		summary := r.Run(context.Background(), context.Background(), files, func(ctx context.Context, path string) error {
+			got = append(got, filepath.Base(path))
+			return nil
+		})
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: processed %v, want %v", tt.name, got, tt.want)
+		}
+		if summary.Postponed != len(files)-len(tt.want) || summary.Skipped != 0 {
+			t.Errorf("%s: got summary %+v", tt.name, summary)
+		}
+		if summary.Complete() != (len(got) == len(files)) {
+			t.Errorf("%s: complete %v with %d of %d files", tt.name, summary.Complete(), len(got), len(files))
+		}
+	}
+}
+
+func TestRunnerNoSpace(t *testing.T) {
+	files := orderFiles(t, 10, 20)
+	path := filepath.Join(t.TempDir(), ".journal")
+	j, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Start(files); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Runner{Jobs: 1, Journal: j, Metrics: NewMetrics()}
+	summary := r.Run(context.Background(), context.Background(), files, func(ctx context.Context, path string) error {
+		if filepath.Base(path) == "b.mkv" {
+			reportSkip(ctx, ErrNoSpace.Error())
+			return fmt.Errorf("%w: output needs more", ErrNoSpace)
+		}
+		return nil
+	})
+	if summary.Processed != 1 || summary.Postponed != 1 || summary.Failed != 0 || summary.Complete() {
+		t.Errorf("got summary %+v", summary)
+	}
+	if err := j.Close(summary.Complete()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the file which did not fit is left for the next run
+	if j, err = OpenJournal(path); err != nil {
+		t.Fatal(err)
+	}
+	if pending := j.Pending(); len(pending) != 1 || filepath.Base(pending[0]) != "b.mkv" {
+		t.Errorf("got pending %v", pending)
+	}
+	_ = j.Close(true)
+}
@@ -0,0 +1,239 @@
+// Package pipeline combines cleanup, AC3 and HEVC conversion of a file into a
+// single ffmpeg invocation.
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"strings"
+
+	"github.com/hranicka/mediatool/internal"
+	"github.com/hranicka/mediatool/internal/ac3"
+	"github.com/hranicka/mediatool/internal/cleaner"
+	"github.com/hranicka/mediatool/internal/hevc"
+)
+
+// Processor collects decisions of the enabled processors and applies them
+// together, so the file is rewritten only once.
+type Processor struct {
+	Exec internal.Executor
+	// Clean, AC3 and HEVC are the steps of the pipeline, nil disables a step.
+	Clean  *cleaner.Processor
+	AC3    *ac3.Processor
+	HEVC   *hevc.Processor
+	DryRun bool
+	Del    bool
+}
+
+// plan contains streams affected by the individual steps.
+type plan struct {
+	remove []internal.Stream
+	audio  []internal.Stream
+	video  []internal.Stream
+}
+
+func (p *Processor) Process(ctx context.Context, src string) error {
+	// read file streams
+	slog.DebugContext(ctx, "opening file", "path", src)
+
+	// check file format
+	if err := internal.CheckExtension(src, nil); err != nil {
+		return err
+	}
+
+	// probe file
+	f, err := internal.Probe(ctx, p.Exec, src)
+	if err != nil {
+		return fmt.Errorf("cannot get file info: %v", err)
+	}
+
+	pl, err := p.detect(ctx, src, f)
+	if err != nil {
+		return err
+	}
+	if len(pl.remove) == 0 && len(pl.audio) == 0 && len(pl.video) == 0 {
+		internal.ReportDecision(ctx, internal.DecisionSkip, "no step needs processing", nil)
+		slog.DebugContext(ctx, "file finished", "file", src)
+		return nil
+	}
+	internal.ReportDecision(ctx, internal.DecisionProcess, pl.reason(), pl.streams())
+
+	slog.InfoContext(ctx, "processing file", "file", src, "remove", pl.remove, "audio", pl.audio, "video", pl.video)
+
+	class := internal.Cheap
+	if len(pl.audio) > 0 || len(pl.video) > 0 {
+		class = internal.Expensive
+	}
+	args, err := p.args(src, pl)
+	if err != nil {
+		return err
+	}
+	err = internal.Execute(ctx, p.Exec, &internal.PlanEntry{
+		File:     src,
+		Streams:  pl.streams(),
+		Class:    class,
+		Duration: f.Format.Duration,
+		Args:     args,
+		Output:   internal.ExpectStreams(f, len(pl.remove) == 0, pl.remove, p.codecs(pl)),
+		Estimate: internal.EstimateOutputSize(f, pl.streams(), p.bitrates(pl)),
+	}, p.DryRun, p.Del)
+	if err != nil {
+		return err
+	}
+
+	slog.DebugContext(ctx, "file finished", "file", src)
+	return nil
+}
+
+// reason describes the steps the plan consists of.
+func (pl plan) reason() string {
+	var steps []string
+	if len(pl.remove) > 0 {
+		steps = append(steps, "clean")
+	}
+	if len(pl.audio) > 0 {
+		steps = append(steps, "ac3")
+	}
+	if len(pl.video) > 0 {
+		steps = append(steps, "hevc")
+	}
+	return "steps: " + strings.Join(steps, ", ")
+}
+
+// streams returns all streams affected by the plan.
+func (pl plan) streams() []internal.Stream {
+	var streams []internal.Stream
+	streams = append(streams, pl.remove...)
+	streams = append(streams, pl.audio...)
+	return append(streams, pl.video...)
+}
+
+// codecs returns target codecs of streams converted by the plan.
+func (p *Processor) codecs(pl plan) map[int]string {
+	codecs := make(map[int]string)
+	if len(pl.audio) > 0 {
+		maps.Copy(codecs, internal.StreamCodecs(pl.audio, p.AC3.TargetCodec()))
+	}
+	maps.Copy(codecs, internal.StreamCodecs(pl.video, internal.CodecHEVC))
+	return codecs
+}
+
+// bitrates returns output bitrates of streams affected by the plan.
+func (p *Processor) bitrates(pl plan) map[int]int64 {
+	bitrates := cleaner.Bitrates(pl.remove)
+	if len(pl.audio) > 0 {
+		maps.Copy(bitrates, p.AC3.Bitrates(pl.audio))
+	}
+	if len(pl.video) > 0 {
+		maps.Copy(bitrates, p.HEVC.Bitrates(pl.video))
+	}
+	return bitrates
+}
+
+// detect asks every enabled step for streams to be processed. Streams removed
+// by the cleanup are not converted.
+func (p *Processor) detect(ctx context.Context, src string, f *internal.FFprobe) (plan, error) {
+	var pl plan
+	var err error
+
+	if p.Clean != nil && accepts(ctx, src, "clean", p.Clean.Extensions) {
+		if pl.remove, err = p.Clean.Detect(ctx, src, f); err != nil {
+			return plan{}, err
+		}
+	}
+
+	if p.AC3 != nil && accepts(ctx, src, "ac3", p.AC3.Extensions) {
+		audio, err := p.AC3.Detect(ctx, src, f)
+		if err != nil {
+			return plan{}, err
+		}
+		for _, s := range audio {
+			if !contains(pl.remove, s) {
+				pl.audio = append(pl.audio, s)
+			}
+		}
+	}
+
+	if p.HEVC != nil && accepts(ctx, src, "hevc", p.HEVC.Extensions) {
+		video, err := p.HEVC.Detect(ctx, src, f)
+		if err != nil {
+			return plan{}, err
+		}
+		for _, s := range video {
+			if !contains(pl.remove, s) {
+				pl.video = append(pl.video, s)
+			}
+		}
+	}
+
+	return pl, nil
+}
+
+// accepts reports whether the step is configured for the file format.
+func accepts(ctx context.Context, src string, step string, extensions []string) bool {
+	if err := internal.CheckExtension(src, extensions); err != nil {
+		slog.DebugContext(ctx, "file format not accepted by step, skipping", "file", src, "step", step)
+		return false
+	}
+	return true
+}
+
+// args returns ffmpeg arguments applying all steps of the plan at once.
+func (p *Processor) args(src string, pl plan) ([]string, error) {
+	var args []string
+	if len(pl.video) > 0 {
+		args = append(args, p.HEVC.InputArgs()...)
+	}
+	args = append(args, "-i", src)
+	if len(pl.video) > 0 {
+		args = append(args, p.HEVC.FilterArgs()...)
+	}
+
+	if len(pl.remove) > 0 {
+		mapArgs, err := cleaner.MapArgs(pl.remove)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, mapArgs...)
+	} else {
+		args = append(args, "-map", "0")
+	}
+
+	args = append(args, "-c", "copy")
+	for _, s := range pl.video {
+		args = append(args, p.HEVC.StreamArgs(s, outputIndex(s, pl.remove))...)
+	}
+	for _, s := range pl.audio {
+		args = append(args, p.AC3.StreamArgs(outputIndex(s, pl.remove))...)
+	}
+
+	if len(pl.remove) > 0 {
+		args = append(args, "-map_metadata:g", "0:g") // remove additional metadata
+	}
+	args = append(args, "-max_muxing_queue_size", "4096")
+	args = append(args, internal.TempPath(src))
+	return args, nil
+}
+
+// outputIndex returns the index of the stream among output streams of its type,
+// which is lower than the input one when preceding streams are removed.
+func outputIndex(s internal.Stream, removed []internal.Stream) int {
+	index := s.TypeIndex
+	for _, r := range removed {
+		if r.CodecType == s.CodecType && r.TypeIndex < s.TypeIndex {
+			index--
+		}
+	}
+	return index
+}
+
+func contains(streams []internal.Stream, s internal.Stream) bool {
+	for _, c := range streams {
+		if c.Index == s.Index {
+			return true
+		}
+	}
+	return false
+}
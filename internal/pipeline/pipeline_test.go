@@ -0,0 +1,64 @@
+package pipeline
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hranicka/mediatool/internal"
+	"github.com/hranicka/mediatool/internal/ac3"
+	"github.com/hranicka/mediatool/internal/cleaner"
+	"github.com/hranicka/mediatool/internal/exectest"
+	"github.com/hranicka/mediatool/internal/hevc"
+)
+
+func TestProcessGolden(t *testing.T) {
+	for _, fixture := range exectest.Fixtures(t, "../testdata/ffprobe/*.json") {
+		name := strings.TrimSuffix(filepath.Base(fixture), ".json")
+		t.Run(name, func(t *testing.T) {
+			rec, dir := exectest.ProcessFixture(t, fixture, func(e *exectest.Recorder, src string) error {
+				p := &Processor{
+					Exec:  e,
+					Clean: &cleaner.Processor{Exec: e},
+					AC3:   &ac3.Processor{Exec: e, MinBitRate: 448000},
+					HEVC: &hevc.Processor{
+						Exec:              e,
+						VaapiDevice:       "/dev/dri/renderD128",
+						EncQualityType:    hevc.EncQualityTypeAuto,
+						EncQualityPercent: 0.6,
+						EncQualityPreset:  20,
+					},
+				}
+				return p.Process(context.Background(), src)
+			})
+
+			calls := rec.Calls(internal.FFmpegPath)
+			if len(calls) > 1 {
+				t.Errorf("expected at most one ffmpeg invocation, got %d", len(calls))
+			}
+			exectest.Golden(t, filepath.Join("testdata", name+".golden"), exectest.Format(calls, dir))
+		})
+	}
+}
+
+func TestOutputIndex(t *testing.T) {
+	removed := []internal.Stream{
+		{Index: 2, CodecType: internal.TypeAudio, TypeIndex: 1},
+		{Index: 5, CodecType: internal.TypeSubtitles, TypeIndex: 0},
+	}
+	tests := []struct {
+		s    internal.Stream
+		want int
+	}{
+		{internal.Stream{Index: 1, CodecType: internal.TypeAudio, TypeIndex: 0}, 0},
+		{internal.Stream{Index: 3, CodecType: internal.TypeAudio, TypeIndex: 2}, 1},
+		{internal.Stream{Index: 0, CodecType: internal.TypeVideo, TypeIndex: 0}, 0},
+		{internal.Stream{Index: 6, CodecType: internal.TypeSubtitles, TypeIndex: 1}, 0},
+	}
+	for _, tt := range tests {
+		if got := outputIndex(tt.s, removed); got != tt.want {
+			t.Errorf("stream %d: expected output index %d, got %d", tt.s.Index, tt.want, got)
+		}
+	}
+}
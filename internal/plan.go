@@ -0,0 +1,220 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PlanEntry is a conversion of a single file. It is either executed right
+// away or written to a plan file to be reviewed and applied later.
+type PlanEntry struct {
+	File    string `json:"file"`
+	Command string `json:"command"`
+	// Size and ModTime fingerprint the source at planning time.
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	// Streams are the streams affected by the conversion.
+	Streams []Stream `json:"streams"`
+	Class   Class    `json:"class"`
+	// Duration of the source as reported by ffprobe, used for progress.
+	Duration string `json:"duration,omitempty"`
+	// Args are ffmpeg arguments, the output is always TempPath(File).
+	Args []string `json:"args"`
+	// Dst is where the converted file is moved to when File is remuxed into
+	// another container, empty means File.
+	Dst string `json:"dst,omitempty"`
+	// Output are streams expected in the converted file, nil skips the check.
+	Output []OutputStream `json:"output,omitempty"`
+	// Estimate is the expected size of the converted file in bytes.
+	Estimate int64 `json:"estimate"`
+}
+
+// Execute converts the file as described by the entry and swaps the result
+// into place. A file whose container cannot carry the output streams is
+// remuxed, see OutputPath. When the context belongs to a planning run, the
+// entry is only recorded.
+func Execute(ctx context.Context, e Executor, entry *PlanEntry, dryRun bool, del bool) error {
+	if entry.Dst == "" {
+		entry.remux()
+	}
+	if rec := planRecordFrom(ctx); rec != nil {
+		return rec.set(entry)
+	}
+
+	dst := entry.File
+	if entry.Dst != "" {
+		dst = entry.Dst
+		slog.InfoContext(ctx, "remuxing into another container", "file", entry.File, "dst", dst)
+		if exists(dst) {
+			return fmt.Errorf("cannot remux into %s: file exists", dst)
+		}
+	}
+
+	slog.DebugContext(ctx, "running ffmpeg", "file", entry.File, "cmd", fmt.Sprintf("%s %v\n", FFmpegPath, strings.Join(entry.Args, " ")))
+	if dryRun {
+		return nil
+	}
+
+	err := ReplaceFile(ctx, entry.File, dst, entry.Class, entry.Estimate, del, func(tmp string) error {
+		return RunFFmpeg(ctx, e, ParseSeconds(entry.Duration), entry.Args...)
+	}, func(tmp string) error {
+		if err := verify(ctx, e, entry, tmp); err != nil {
+			return fmt.Errorf("verification failed: %v", err)
+		}
+		return nil
+	})
+	if err == nil && dst != entry.File {
+		reportOutput(ctx, dst)
+	}
+	return err
+}
+
+// remux sets Dst and the output format when the container of File cannot
+// carry the output streams.
+func (e *PlanEntry) remux() {
+	dst := OutputPath(e.File, e.Output)
+	if dst == e.File || len(e.Args) == 0 {
+		return
+	}
+	e.Dst = dst
+	muxer := "matroska"
+	if c := ContainerOf(dst); c != nil {
+		muxer = c.Muxer
+	}
+	last := len(e.Args) - 1
+	e.Args = append(e.Args[:last:last], "-f", muxer, e.Args[last])
+}
+
+// Verify checks the entry can be applied: the source is unchanged since
+// planning, it is the only input of ffmpeg, and ffmpeg writes to the temporary
+// file swapped into place.
+func (e *PlanEntry) Verify() error {
+	if len(e.Args) == 0 || e.Args[len(e.Args)-1] != TempPath(e.File) {
+		return fmt.Errorf("output must be %s", TempPath(e.File))
+	}
+	inputs := 0
+	for i, arg := range e.Args[:len(e.Args)-1] {
+		if arg != "-i" {
+			continue
+		}
+		if i+1 >= len(e.Args)-1 || e.Args[i+1] != e.File {
+			return fmt.Errorf("input must be %s", e.File)
+		}
+		inputs++
+	}
+	if inputs != 1 {
+		return fmt.Errorf("input must be %s", e.File)
+	}
+	if e.Dst != "" && e.Dst != remuxPath(e.File) {
+		return fmt.Errorf("remuxed output must be %s", remuxPath(e.File))
+	}
+
+	info, err := os.Stat(e.File)
+	if err != nil {
+		return err
+	}
+	if info.Size() != e.Size || !info.ModTime().Equal(e.ModTime) {
+		return errors.New("source changed since planning")
+	}
+	return nil
+}
+
+// Plan writes planned conversions as JSON Lines.
+type Plan struct {
+	mu   sync.Mutex
+	f    *os.File
+	enc  *json.Encoder
+	size int
+}
+
+func CreatePlan(path string) (*Plan, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create plan: %w", err)
+	}
+	return &Plan{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (p *Plan) Write(e *PlanEntry) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.size++
+	return p.enc.Encode(e)
+}
+
+// Close closes the plan file and returns the number of written entries.
+func (p *Plan) Close() (int, error) {
+	return p.size, p.f.Close()
+}
+
+// ReadPlan reads entries of a plan file, blank lines are skipped.
+func ReadPlan(path string) ([]*PlanEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*PlanEntry
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 16*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		e := &PlanEntry{}
+		if err := json.Unmarshal(line, e); err != nil {
+			return nil, fmt.Errorf("invalid plan line %d: %v", n, err)
+		}
+		if e.File == "" {
+			return nil, fmt.Errorf("invalid plan line %d: missing file", n)
+		}
+		if seen[e.File] {
+			return nil, fmt.Errorf("invalid plan line %d: duplicate file %s", n, e.File)
+		}
+		seen[e.File] = true
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// planRecord holds the entry planned for a file.
+type planRecord struct {
+	command string
+	entry   *PlanEntry
+}
+
+func (r *planRecord) set(e *PlanEntry) error {
+	info, err := os.Stat(e.File)
+	if err != nil {
+		return fmt.Errorf("cannot fingerprint file: %v", err)
+	}
+	e.Command = r.command
+	e.Size = info.Size()
+	e.ModTime = info.ModTime()
+	r.entry = e
+	return nil
+}
+
+type planKey struct{}
+
+func withPlanRecord(ctx context.Context, r *planRecord) context.Context {
+	return context.WithValue(ctx, planKey{}, r)
+}
+
+func planRecordFrom(ctx context.Context) *planRecord {
+	r, _ := ctx.Value(planKey{}).(*planRecord)
+	return r
+}
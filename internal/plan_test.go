@@ -0,0 +1,81 @@
+package internal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPlanApply(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "movie.mkv")
+	if err := os.WriteFile(src, []byte("movie"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	files, err := StatFiles(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "plan.jsonl")
+	plan, err := CreatePlan(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner := &Runner{Jobs: 1, Plan: plan, Command: "test"}
+	summary := runner.Run(context.Background(), context.Background(), files, func(ctx context.Context, path string) error {
+		return Execute(ctx, nil, &PlanEntry{
+			File:    path,
+			Streams: []Stream{{Index: 1}},
+			Class:   Expensive,
+			Args:    []string{"-i", path, TempPath(path)},
+		}, false, false)
+	})
+	if summary.Processed != 1 {
+		t.Fatalf("unexpected summary: %+v", summary)
+	}
+	if n, err := plan.Close(); err != nil || n != 1 {
+		t.Fatalf("unexpected plan close: %d, %v", n, err)
+	}
+
+	entries, err := ReadPlan(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Command != "test" || entries[0].Class != Expensive || entries[0].Size != 5 {
+		t.Fatalf("unexpected plan: %+v", entries)
+	}
+	if err := entries[0].Verify(); err != nil {
+		t.Errorf("unexpected verify error: %v", err)
+	}
+
+	// edited plans must not read or write other files
+	other := filepath.Join(dir, "other.mkv")
+	for _, args := range [][]string{
+		{"-i", other, TempPath(src)},
+		{"-i", src, "-i", other, TempPath(src)},
+		{src, TempPath(src)},
+	} {
+		e := *entries[0]
+		e.Args = args
+		if err := e.Verify(); err == nil {
+			t.Errorf("args %v not refused", args)
+		}
+	}
+	e := *entries[0]
+	e.Dst = other
+	if err := e.Verify(); err == nil {
+		t.Error("remux over another file not refused")
+	}
+
+	// source changed after planning
+	mtime := time.Now().Add(time.Hour)
+	if err := os.Chtimes(src, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if err := entries[0].Verify(); err == nil {
+		t.Error("changed source not refused")
+	}
+}
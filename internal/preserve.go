@@ -0,0 +1,49 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+var (
+	// PreserveMetadata enables copying of times, ownership, permissions and
+	// extended attributes of the original onto the converted file.
+	PreserveMetadata = true
+)
+
+// preserveMetadata replicates metadata of the original file src onto the
+// converted file dst. Metadata which cannot be copied is logged and reported,
+// it does not stop the swap.
+func preserveMetadata(ctx context.Context, src string, dst string) {
+	if !PreserveMetadata {
+		return
+	}
+
+	info, err := os.Stat(src)
+	if err != nil {
+		metadataWarning(ctx, src, fmt.Sprintf("cannot read metadata: %v", err))
+		return
+	}
+
+	for _, warning := range copyOwner(info, dst) {
+		metadataWarning(ctx, src, warning)
+	}
+	// after chown, which clears the setuid and setgid bits
+	if err := os.Chmod(dst, info.Mode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+		metadataWarning(ctx, src, fmt.Sprintf("cannot copy permissions: %v", err))
+	}
+	for _, warning := range copyXattrs(src, dst) {
+		metadataWarning(ctx, src, warning)
+	}
+	// last, as the other changes may touch the times
+	if err := os.Chtimes(dst, accessTime(info), info.ModTime()); err != nil {
+		metadataWarning(ctx, src, fmt.Sprintf("cannot copy times: %v", err))
+	}
+}
+
+func metadataWarning(ctx context.Context, src string, warning string) {
+	slog.WarnContext(ctx, "metadata not preserved", "file", src, "reason", warning)
+	reportWarning(ctx, warning)
+}
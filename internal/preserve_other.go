@@ -0,0 +1,11 @@
+//go:build !unix
+
+package internal
+
+import (
+	"os"
+)
+
+func copyOwner(_ os.FileInfo, _ string) []string {
+	return nil
+}
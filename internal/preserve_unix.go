@@ -0,0 +1,33 @@
+//go:build unix
+
+package internal
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// copyOwner sets the owner and group of info on dst. When the owner cannot be
+// changed, e.g. without root privileges, at least the group is copied.
+func copyOwner(info os.FileInfo, dst string) (warnings []string) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	dstInfo, err := os.Stat(dst)
+	if err != nil {
+		return []string{fmt.Sprintf("cannot copy owner: %v", err)}
+	}
+	if dstSt, ok := dstInfo.Sys().(*syscall.Stat_t); ok && dstSt.Uid == st.Uid && dstSt.Gid == st.Gid {
+		return nil
+	}
+
+	if err := os.Chown(dst, int(st.Uid), int(st.Gid)); err != nil {
+		warnings = append(warnings, fmt.Sprintf("cannot copy owner: %v", err))
+		if err := os.Chown(dst, -1, int(st.Gid)); err != nil {
+			warnings = append(warnings, fmt.Sprintf("cannot copy group: %v", err))
+		}
+	}
+	return warnings
+}
@@ -0,0 +1,284 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ProgressLogInterval is how often a progress record is logged for every
+	// running conversion.
+	ProgressLogInterval = time.Minute
+)
+
+// Progress is a snapshot of the ffmpeg -progress output.
+type Progress struct {
+	OutTime   time.Duration
+	Speed     float64
+	FPS       float64
+	TotalSize int64
+	Duration  time.Duration
+	End       bool
+}
+
+// Ratio returns the completed part of the media in range 0..1, or 0 when the
+// total duration is unknown.
+func (p Progress) Ratio() float64 {
+	if p.End {
+		return 1
+	}
+	if p.Duration <= 0 {
+		return 0
+	}
+	return min(float64(p.OutTime)/float64(p.Duration), 1)
+}
+
+// ETA estimates the remaining wall time from the current encoding speed.
+func (p Progress) ETA() time.Duration {
+	if p.Duration <= 0 || p.Speed <= 0 || p.OutTime >= p.Duration {
+		return 0
+	}
+	return time.Duration(float64(p.Duration-p.OutTime) / p.Speed)
+}
+
+// ParseProgress reads the key=value blocks written by ffmpeg -progress and
+// calls fn at the end of every block.
+func ParseProgress(r io.Reader, fn func(Progress)) error {
+	var p Progress
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+
+		switch key {
+		case "out_time_us":
+			if us, err := strconv.ParseInt(value, 10, 64); err == nil && us >= 0 {
+				p.OutTime = time.Duration(us) * time.Microsecond
+			}
+		case "fps":
+			p.FPS, _ = strconv.ParseFloat(value, 64)
+		case "total_size":
+			p.TotalSize, _ = strconv.ParseInt(value, 10, 64)
+		case "speed":
+			p.Speed, _ = strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "x"), 64)
+		case "progress":
+			p.End = value == "end"
+			fn(p)
+		}
+	}
+	return scanner.Err()
+}
+
+// ParseSeconds converts ffprobe's fractional seconds, e.g. "5400.120000", to a
+// duration. Invalid input results in zero.
+func ParseSeconds(s string) time.Duration {
+	sec, err := strconv.ParseFloat(s, 64)
+	if err != nil || sec < 0 {
+		return 0
+	}
+	return time.Duration(sec * float64(time.Second))
+}
+
+type progressKey struct{}
+
+// WithProgress returns a context which makes RunFFmpeg report to fn.
+func WithProgress(ctx context.Context, fn func(Progress)) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+func progressFunc(ctx context.Context) func(Progress) {
+	if fn, ok := ctx.Value(progressKey{}).(func(Progress)); ok {
+		return fn
+	}
+	return func(Progress) {}
+}
+
+// Batch tracks the progress of a set of files. The overall ratio is weighted by
+// file sizes, so a large movie counts more than a short episode.
+type Batch struct {
+	mu         sync.Mutex
+	start      time.Time
+	totalBytes int64
+	doneBytes  int64
+	active     []*batchFile
+	status     *statusLine
+}
+
+type batchFile struct {
+	path    string
+	size    int64
+	p       Progress
+	lastLog time.Time
+}
+
+// NewBatch creates a tracker for files of the given total size. When the
+// standard error is a terminal, a progress bar is drawn there below the log.
+func NewBatch(totalBytes int64) *Batch {
+	b := &Batch{start: time.Now(), totalBytes: totalBytes}
+	if isTerminal(os.Stderr) {
+		b.status = stderr
+	}
+	return b
+}
+
+// Track registers a file as being processed. The returned context reports
+// ffmpeg progress of the file and done must be called once it is finished.
+func (b *Batch) Track(ctx context.Context, path string, size int64) (_ context.Context, done func()) {
+	f := &batchFile{path: path, size: size, lastLog: time.Now()}
+
+	b.mu.Lock()
+	b.active = append(b.active, f)
+	b.mu.Unlock()
+
+	ctx = WithProgress(ctx, func(p Progress) {
+		b.update(f, p)
+	})
+	return ctx, func() {
+		b.finish(f)
+	}
+}
+
+// Close removes the progress bar from the terminal.
+func (b *Batch) Close() {
+	if b.status != nil {
+		b.status.Set("")
+	}
+}
+
+func (b *Batch) update(f *batchFile, p Progress) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	f.p = p
+	ratio, eta := b.estimate()
+	if time.Since(f.lastLog) >= ProgressLogInterval {
+		f.lastLog = time.Now()
+		slog.Info("conversion progress",
+			"file", f.path,
+			"percent", fmt.Sprintf("%.1f", p.Ratio()*100),
+			"speed", p.Speed,
+			"fps", p.FPS,
+			"size", p.TotalSize,
+			"eta", p.ETA().Round(time.Second).String(),
+			"batch_percent", fmt.Sprintf("%.1f", ratio*100),
+			"batch_eta", eta.Round(time.Second).String(),
+		)
+	}
+	b.draw(ratio, eta)
+}
+
+func (b *Batch) finish(f *batchFile) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i, a := range b.active {
+		if a == f {
+			b.active = append(b.active[:i], b.active[i+1:]...)
+			break
+		}
+	}
+	b.doneBytes += f.size
+	b.draw(b.estimate())
+}
+
+// estimate returns the overall ratio and the remaining time extrapolated from
+// the time elapsed so far. Must be called with b.mu held.
+func (b *Batch) estimate() (float64, time.Duration) {
+	if b.totalBytes <= 0 {
+		return 0, 0
+	}
+
+	processed := float64(b.doneBytes)
+	for _, f := range b.active {
+		processed += f.p.Ratio() * float64(f.size)
+	}
+	ratio := min(processed/float64(b.totalBytes), 1)
+	if ratio <= 0 {
+		return 0, 0
+	}
+
+	elapsed := time.Since(b.start)
+	return ratio, time.Duration(float64(elapsed) / ratio * (1 - ratio))
+}
+
+// draw renders the progress bar. Must be called with b.mu held.
+func (b *Batch) draw(ratio float64, eta time.Duration) {
+	if b.status == nil {
+		return
+	}
+
+	const width = 20
+	filled := int(ratio * width)
+	line := fmt.Sprintf("[%s%s] %5.1f%% ETA %s", strings.Repeat("#", filled), strings.Repeat(".", width-filled), ratio*100, formatETA(eta))
+	for _, f := range b.active {
+		line += fmt.Sprintf(" | %s %.1f%% %.2fx ETA %s", filepath.Base(f.path), f.p.Ratio()*100, f.p.Speed, formatETA(f.p.ETA()))
+	}
+	b.status.Set(line)
+}
+
+func formatETA(d time.Duration) string {
+	if d <= 0 {
+		return "--:--:--"
+	}
+	d = d.Round(time.Second)
+	return fmt.Sprintf("%d:%02d:%02d", int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60)
+}
+
+// defaultColumns is the assumed terminal width when $COLUMNS is not set.
+const defaultColumns = 120
+
+// statusLine keeps a single redrawable line at the bottom of a terminal while
+// regular output is written above it.
+type statusLine struct {
+	mu   sync.Mutex
+	w    io.Writer
+	line string
+}
+
+func (s *statusLine) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.line != "" {
+		_, _ = io.WriteString(s.w, "\r\033[K")
+	}
+	n, err := s.w.Write(p)
+	if s.line != "" {
+		_, _ = io.WriteString(s.w, s.line)
+	}
+	return n, err
+}
+
+func (s *statusLine) Set(line string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cols, err := strconv.Atoi(os.Getenv("COLUMNS"))
+	if err != nil || cols <= 0 {
+		cols = defaultColumns
+	}
+	if r := []rune(line); len(r) >= cols {
+		line = string(r[:cols-1])
+	}
+	s.line = line
+	_, _ = io.WriteString(s.w, "\r\033[K"+line)
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,36 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseProgress(t *testing.T) {
+	out := "frame=120\nfps=24.50\ntotal_size=1048576\nout_time_us=5000000\nout_time=00:00:05.000000\nspeed=2.5x\nprogress=continue\n" +
+		"frame=240\nfps=N/A\ntotal_size=2097152\nout_time_us=10000000\nspeed=N/A\nprogress=end\n"
+
+	var got []Progress
+	if err := ParseProgress(strings.NewReader(out), func(p Progress) {
+		p.Duration = 20 * time.Second
+		got = append(got, p)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("expected 2 progress blocks, got %d", len(got))
+	}
+	if p := got[0]; p.OutTime != 5*time.Second || p.Speed != 2.5 || p.FPS != 24.5 || p.TotalSize != 1048576 || p.End {
+		t.Errorf("unexpected first block: %+v", p)
+	}
+	if r := got[0].Ratio(); r != 0.25 {
+		t.Errorf("expected ratio 0.25, got %v", r)
+	}
+	if eta := got[0].ETA(); eta != 6*time.Second {
+		t.Errorf("expected eta 6s, got %v", eta)
+	}
+	if p := got[1]; !p.End || p.Ratio() != 1 {
+		t.Errorf("unexpected last block: %+v", p)
+	}
+}
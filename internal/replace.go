@@ -0,0 +1,52 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// ReplaceFile runs convert writing to a temporary file next to src and then
+// swaps the result into place as dst, which is src unless the file is
+// remuxed, see swap. The original is kept as OldPath(src), or removed when del
+// is set. Convert waits for a free slot of the operation class and for free
+// space for the output of estimated size. Check verifies the temporary file
+// after the slot is released, so it may acquire slots itself, it is optional.
+func ReplaceFile(ctx context.Context, src string, dst string, class Class, estimate int64, del bool, convert func(tmp string) error, check func(tmp string) error) error {
+	release, err := Acquire(ctx, class)
+	if err != nil {
+		return fmt.Errorf("conversion interrupted: %w", err)
+	}
+
+	releaseSpace, err := space.reserve(ctx, src, estimate)
+	if err != nil {
+		release()
+		if errors.Is(err, ErrNoSpace) {
+			slog.WarnContext(ctx, "skipping file", "file", src, "reason", err)
+			reportSkip(ctx, err.Error())
+			return err
+		}
+		return fmt.Errorf("conversion interrupted: %w", err)
+	}
+	defer releaseSpace()
+
+	tmp := TempPath(src)
+	SetJobState(ctx, StateConverting)
+	err = convert(tmp)
+	release()
+	if err == nil && check != nil && ctx.Err() == nil {
+		err = check(tmp)
+	}
+	if err != nil {
+		RemoveTemp(tmp)
+		if ctx.Err() != nil {
+			slog.WarnContext(ctx, "conversion interrupted", "file", src, "tmp", tmp)
+			return fmt.Errorf("conversion interrupted: %w", ctx.Err())
+		}
+		return fmt.Errorf("cannot convert file: %v", err)
+	}
+
+	SetJobState(ctx, StateSwapping)
+	return swap(ctx, src, dst, tmp, del)
+}
@@ -0,0 +1,277 @@
+package internal
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DecisionProcess means the file was (or in dry mode would be) rewritten.
+	DecisionProcess = "process"
+	// DecisionSkip means the file did not need any change.
+	DecisionSkip = "skip"
+	// DecisionReport means the file was only inspected, e.g. by dupfinder.
+	DecisionReport = "report"
+)
+
+// ReportEntry describes what happened to a single file.
+type ReportEntry struct {
+	File       string    `json:"file"`
+	Command    string    `json:"command"`
+	Started    time.Time `json:"started"`
+	Streams    []Stream  `json:"streams,omitempty"`
+	Decision   string    `json:"decision"`
+	Reason     string    `json:"reason,omitempty"`
+	Affected   []Stream  `json:"affected,omitempty"`
+	DryRun     bool      `json:"dry_run,omitempty"`
+	FFmpeg     []string  `json:"ffmpeg,omitempty"`
+	Duration   float64   `json:"duration_sec"`
+	SizeBefore int64     `json:"size_before"`
+	SizeAfter  int64     `json:"size_after"`
+	Error      string    `json:"error,omitempty"`
+	Warnings   []string  `json:"warnings,omitempty"`
+	FFmpegTime float64   `json:"ffmpeg_sec,omitempty"`
+	Speed      float64   `json:"speed,omitempty"`
+	// Output is the converted file when it was remuxed into another
+	// container.
+	Output string `json:"output,omitempty"`
+}
+
+// ReportWriter writes report entries in a specific format.
+type ReportWriter interface {
+	Write(e *ReportEntry) error
+	Close() error
+}
+
+// Report writes entries of processed files to all its writers.
+type Report struct {
+	mu      sync.Mutex
+	writers []ReportWriter
+}
+
+// OpenReport creates report files. Empty paths are skipped, the report is nil
+// when there are none.
+func OpenReport(jsonPath string, csvPath string) (*Report, error) {
+	r := &Report{}
+	if jsonPath != "" {
+		f, err := os.Create(jsonPath)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create report: %w", err)
+		}
+		r.writers = append(r.writers, NewJSONReport(f))
+	}
+	if csvPath != "" {
+		f, err := os.Create(csvPath)
+		if err != nil {
+			_ = r.Close()
+			return nil, fmt.Errorf("cannot create report: %w", err)
+		}
+		r.writers = append(r.writers, NewCSVReport(f))
+	}
+	if len(r.writers) == 0 {
+		return nil, nil
+	}
+	return r, nil
+}
+
+func (r *Report) Write(e *ReportEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, w := range r.writers {
+		if err := w.Write(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Report) Close() error {
+	var firstErr error
+	for _, w := range r.writers {
+		if err := w.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// jsonReport writes entries as JSON Lines.
+type jsonReport struct {
+	w   io.WriteCloser
+	enc *json.Encoder
+}
+
+func NewJSONReport(w io.WriteCloser) ReportWriter {
+	return &jsonReport{w: w, enc: json.NewEncoder(w)}
+}
+
+func (r *jsonReport) Write(e *ReportEntry) error {
+	return r.enc.Encode(e)
+}
+
+func (r *jsonReport) Close() error {
+	return r.w.Close()
+}
+
+// csvReport writes entries as CSV with a header, streams are reduced to their
+// indexes.
+type csvReport struct {
+	w      io.WriteCloser
+	csv    *csv.Writer
+	header bool
+}
+
+func NewCSVReport(w io.WriteCloser) ReportWriter {
+	return &csvReport{w: w, csv: csv.NewWriter(w)}
+}
+
+var csvHeader = []string{
+	"file", "command", "started", "streams", "decision", "reason", "affected", "dry_run",
+	"ffmpeg", "duration_sec", "size_before", "size_after", "error", "warnings", "ffmpeg_sec", "speed",
+}
+
+func (r *csvReport) Write(e *ReportEntry) error {
+	if !r.header {
+		r.header = true
+		if err := r.csv.Write(csvHeader); err != nil {
+			return err
+		}
+	}
+
+	err := r.csv.Write([]string{
+		e.File,
+		e.Command,
+		e.Started.Format(time.RFC3339),
+		strconv.Itoa(len(e.Streams)),
+		e.Decision,
+		e.Reason,
+		streamIndexes(e.Affected),
+		strconv.FormatBool(e.DryRun),
+		strings.Join(e.FFmpeg, " "),
+		strconv.FormatFloat(e.Duration, 'f', 3, 64),
+		strconv.FormatInt(e.SizeBefore, 10),
+		strconv.FormatInt(e.SizeAfter, 10),
+		e.Error,
+		strings.Join(e.Warnings, "; "),
+		strconv.FormatFloat(e.FFmpegTime, 'f', 3, 64),
+		strconv.FormatFloat(e.Speed, 'f', 2, 64),
+	})
+	if err != nil {
+		return err
+	}
+	r.csv.Flush()
+	return r.csv.Error()
+}
+
+func (r *csvReport) Close() error {
+	r.csv.Flush()
+	if err := r.csv.Error(); err != nil {
+		_ = r.w.Close()
+		return err
+	}
+	return r.w.Close()
+}
+
+func streamIndexes(streams []Stream) string {
+	indexes := make([]string, 0, len(streams))
+	for _, s := range streams {
+		indexes = append(indexes, strconv.Itoa(s.Index))
+	}
+	return strings.Join(indexes, ",")
+}
+
+// finish records the outcome of processing, e is allowed to be nil.
+func (e *ReportEntry) finish(err error) {
+	if e == nil {
+		return
+	}
+	e.Duration = time.Since(e.Started).Seconds()
+	if err != nil {
+		e.Error = err.Error()
+	}
+	e.SizeAfter = e.SizeBefore
+	file := e.File
+	if e.Output != "" {
+		file = e.Output
+	}
+	if info, statErr := os.Stat(file); statErr == nil {
+		e.SizeAfter = info.Size()
+	}
+}
+
+type reportKey struct{}
+
+func withReportEntry(ctx context.Context, e *ReportEntry) context.Context {
+	return context.WithValue(ctx, reportKey{}, e)
+}
+
+func reportEntry(ctx context.Context) *ReportEntry {
+	e, _ := ctx.Value(reportKey{}).(*ReportEntry)
+	return e
+}
+
+// ReportDecision records what is going to happen to the file processed with
+// ctx and why. A later call overrides the previous decision.
+func ReportDecision(ctx context.Context, decision string, reason string, affected []Stream) {
+	if e := reportEntry(ctx); e != nil {
+		e.Decision = decision
+		e.Reason = reason
+		e.Affected = affected
+	}
+}
+
+// reportSkip records the file was skipped after all, keeping the affected
+// streams.
+func reportSkip(ctx context.Context, reason string) {
+	if e := reportEntry(ctx); e != nil {
+		e.Decision = DecisionSkip
+		e.Reason = reason
+	}
+}
+
+// reportWarning records a problem which did not stop processing of the file.
+func reportWarning(ctx context.Context, warning string) {
+	if e := reportEntry(ctx); e != nil {
+		e.Warnings = append(e.Warnings, warning)
+	}
+}
+
+// reportProbe records streams of the file processed with ctx.
+func reportProbe(ctx context.Context, f *FFprobe) {
+	if e := reportEntry(ctx); e != nil {
+		e.Streams = f.Streams
+	}
+}
+
+// reportCommand records the ffmpeg command run for the file processed with ctx.
+func reportCommand(ctx context.Context, name string, args []string) {
+	if e := reportEntry(ctx); e != nil {
+		e.FFmpeg = append([]string{name}, args...)
+	}
+}
+
+// reportFFmpegTime records the wall time and the last reported encoding speed
+// of ffmpeg run for the file processed with ctx.
+func reportFFmpegTime(ctx context.Context, d time.Duration, speed float64) {
+	if e := reportEntry(ctx); e != nil {
+		e.FFmpegTime += d.Seconds()
+		e.Speed = speed
+	}
+}
+
+// reportOutput records the converted file of the file processed with ctx
+// when it differs from the source.
+func reportOutput(ctx context.Context, path string) {
+	if e := reportEntry(ctx); e != nil {
+		e.Output = path
+	}
+}
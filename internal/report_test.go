@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunnerReport(t *testing.T) {
+	dir := t.TempDir()
+	var files []File
+	for _, name := range []string{"a.mkv", "b.mkv", "c.mkv"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		info, _ := os.Stat(path)
+		files = append(files, File{Path: path, Info: info})
+	}
+
+	jsonPath, csvPath := filepath.Join(dir, "report.jsonl"), filepath.Join(dir, "report.csv")
+	report, err := OpenReport(jsonPath, csvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runner := &Runner{Jobs: 2, Report: report, Command: "test"}
+	runner.Run(context.Background(), context.Background(), files, func(ctx context.Context, path string) error {
+		switch filepath.Base(path) {
+		case "a.mkv":
+			ReportDecision(ctx, DecisionProcess, "needed", []Stream{{Index: 1}, {Index: 3}})
+			return os.WriteFile(path, []byte("converted"), 0o644)
+		case "b.mkv":
+			ReportDecision(ctx, DecisionSkip, "not needed", nil)
+			return nil
+		}
+		return errors.New("broken")
+	})
+	if err := report.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(jsonPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var entries []ReportEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e ReportEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+
+	if len(entries) != 3 {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+	if e := entries[0]; e.File != files[0].Path || e.Decision != DecisionProcess || len(e.Affected) != 2 || e.SizeBefore != 5 || e.SizeAfter != 9 {
+		t.Errorf("unexpected processed entry: %+v", e)
+	}
+	if e := entries[1]; e.Decision != DecisionSkip || e.Reason != "not needed" || e.Error != "" {
+		t.Errorf("unexpected skipped entry: %+v", e)
+	}
+	if e := entries[2]; e.Error != "broken" || e.Command != "test" {
+		t.Errorf("unexpected failed entry: %+v", e)
+	}
+
+	c, err := os.Open(csvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	rows, err := csv.NewReader(c).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 4 || rows[0][0] != "file" || rows[1][6] != "1,3" || rows[3][12] != "broken" {
+		t.Errorf("unexpected csv: %v", rows)
+	}
+}
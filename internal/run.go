@@ -0,0 +1,284 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Summary contains aggregated results of a batch run.
+type Summary struct {
+	Total       int `json:"total"`
+	Processed   int `json:"processed"`
+	Failed      int `json:"failed"`
+	Interrupted int `json:"interrupted"`
+	Skipped     int `json:"skipped"`
+	// Postponed counts files not started because the budget was exhausted or
+	// skipped because their output would not fit on the disk.
+	Postponed int `json:"postponed"`
+	// Unreadable counts paths which could not be read while collecting files.
+	Unreadable int `json:"unreadable"`
+}
+
+// Complete reports whether every file of the batch got processed.
+func (s Summary) Complete() bool {
+	return s.Interrupted == 0 && s.Skipped == 0 && s.Postponed == 0
+}
+
+// StatFiles returns File entries for explicitly given paths.
+func StatFiles(paths ...string) ([]File, error) {
+	files := make([]File, 0, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, File{Path: path, Info: info})
+	}
+	return files, nil
+}
+
+// Runner processes a batch of files.
+type Runner struct {
+	// Jobs is the number of files processed concurrently.
+	Jobs int
+	// Journal records file states so an interrupted batch can be resumed, it
+	// is optional.
+	Journal *Journal
+	// Report receives an entry for every finished file, it is optional.
+	Report *Report
+	// Command names the operation in report entries.
+	Command string
+	// DryRun marks report entries as not performed.
+	DryRun bool
+	// Plan receives conversions instead of executing them, it is optional.
+	Plan *Plan
+	// Metrics counts results of finished files, it is optional.
+	Metrics *Metrics
+	// Webhook is notified about every finished file, it is optional.
+	Webhook *Webhook
+	// Order is the order files are processed in.
+	Order Order
+	// Budget limits the files started, the rest is postponed.
+	Budget Budget
+}
+
+// Run calls fn for every file using up to r.Jobs concurrent workers while
+// tracking the batch progress. No new file is started once walkCtx is done, fn
+// receives a context derived from runCtx.
+//
+// With more than one job, log records of every file are held back until the
+// file is finished and emitted in the order of files, so the output does not
+// depend on scheduling. Progress records are logged immediately.
+func (r *Runner) Run(walkCtx context.Context, runCtx context.Context, files []File, fn func(ctx context.Context, path string) error) Summary {
+	files = r.sort(walkCtx, files, fn)
+	batch := NewBatch(TotalSize(files))
+	defer batch.Close()
+
+	jobs := max(min(r.Jobs, len(files)), 1)
+	buffered := jobs > 1
+
+	var (
+		mu      sync.Mutex
+		summary = Summary{Total: len(files)}
+		buffers = make([]*logBuffer, len(files))
+		entries = make([]*ReportEntry, len(files))
+		planned = make([]*planRecord, len(files))
+		done    = make([]bool, len(files))
+		next    int
+	)
+	for i := range buffers {
+		buffers[i] = &logBuffer{}
+	}
+
+	// finish marks the file as done and flushes logs of all leading files.
+	finish := func(i int, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if errors.Is(err, ErrNoSpace) {
+			summary.Postponed++
+		} else if err != nil && runCtx.Err() != nil {
+			summary.Interrupted++
+		} else if err != nil {
+			summary.Failed++
+		} else {
+			summary.Processed++
+		}
+		done[i] = true
+		for next < len(files) && done[next] {
+			buffers[next].flush()
+			r.report(entries[next])
+			r.plan(planned[next])
+			next++
+		}
+	}
+
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				f := files[i]
+				ctx, untrack := batch.Track(runCtx, f.Path, f.Info.Size())
+				if buffered {
+					ctx = withLogBuffer(ctx, buffers[i])
+				}
+				if r.Journal != nil {
+					ctx = withJob(ctx, r.Journal, f.Path)
+					r.Journal.Set(f.Path, StateProbing, nil)
+				}
+				if r.Report != nil || r.Metrics != nil || r.Webhook != nil {
+					entries[i] = &ReportEntry{
+						File:       f.Path,
+						Command:    r.Command,
+						Started:    time.Now(),
+						SizeBefore: f.Info.Size(),
+						DryRun:     r.DryRun,
+					}
+					ctx = withReportEntry(ctx, entries[i])
+				}
+				if r.Plan != nil {
+					planned[i] = &planRecord{command: r.Command}
+					ctx = withPlanRecord(ctx, planned[i])
+				}
+
+				err := fn(ctx, f.Path)
+				// files which do not fit are skipped and left for another run
+				noSpace := errors.Is(err, ErrNoSpace)
+				if err != nil && !noSpace {
+					slog.ErrorContext(ctx, "could not process", "file", f.Path, "error", err)
+				}
+				untrack()
+				if noSpace {
+					entries[i].finish(nil)
+				} else {
+					entries[i].finish(err)
+				}
+				if err == nil || runCtx.Err() == nil {
+					r.observe(entries[i])
+				}
+				r.record(runCtx, f.Path, err)
+				finish(i, err)
+			}
+		}()
+	}
+
+	var deadline <-chan time.Time
+	if !r.Budget.Deadline.IsZero() {
+		timer := time.NewTimer(time.Until(r.Budget.Deadline))
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	queued, postponed := 0, 0
+	var queuedSize int64
+	var exhausted, why string
+queue:
+	for i, f := range files {
+		if walkCtx.Err() != nil {
+			break
+		}
+		if reason, all := r.Budget.admit(queued, queuedSize, f.Info.Size()); all {
+			exhausted = reason
+			break
+		} else if reason != "" {
+			slog.Debug("file postponed", "file", f.Path, "reason", reason)
+			postponed++
+			why = reason
+			continue
+		}
+		select {
+		case queue <- i:
+			queued++
+			queuedSize += f.Info.Size()
+		case <-walkCtx.Done():
+		case <-deadline:
+			exhausted = "deadline passed"
+			break queue
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	// flush logs of files which did not get their turn
+	for ; next < len(files); next++ {
+		buffers[next].flush()
+	}
+
+	if exhausted != "" {
+		postponed, why = len(files)-queued, exhausted
+	}
+	summary.Postponed += postponed
+	summary.Skipped = len(files) - queued - postponed
+	if postponed > 0 {
+		slog.Info("budget exhausted, remaining files postponed", "remaining", postponed, "reason", why)
+	}
+	if summary.Skipped > 0 {
+		slog.Warn("processing interrupted, remaining files skipped", "remaining", summary.Skipped)
+	}
+	slog.Info("batch finished", "total", summary.Total, "processed", summary.Processed, "failed", summary.Failed, "interrupted", summary.Interrupted, "skipped", summary.Skipped, "postponed", summary.Postponed)
+	return summary
+}
+
+// sort orders files, estimating savings at most until the deadline of the
+// budget, so the estimation cannot use up the time for processing.
+func (r *Runner) sort(ctx context.Context, files []File, fn func(ctx context.Context, path string) error) []File {
+	if !r.Budget.Deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, r.Budget.Deadline)
+		defer cancel()
+	}
+	return SortFiles(ctx, files, r.Order, r.Jobs, fn)
+}
+
+// report writes the entry of a finished file.
+func (r *Runner) report(e *ReportEntry) {
+	if e == nil || r.Report == nil {
+		return
+	}
+	if err := r.Report.Write(e); err != nil {
+		slog.Error("could not write report", "file", e.File, "error", err)
+	}
+}
+
+// observe passes the entry of a finished, not interrupted file to metrics and
+// the webhook.
+func (r *Runner) observe(e *ReportEntry) {
+	if r.Metrics != nil {
+		r.Metrics.Observe(e)
+	}
+	if r.Webhook != nil {
+		r.Webhook.FileFinished(e)
+	}
+}
+
+// plan writes the conversion planned for a finished file.
+func (r *Runner) plan(rec *planRecord) {
+	if rec == nil || rec.entry == nil {
+		return
+	}
+	if err := r.Plan.Write(rec.entry); err != nil {
+		slog.Error("could not write plan", "file", rec.entry.File, "error", err)
+	}
+}
+
+// record stores the result of a file in the journal. Files interrupted by
+// cancellation or postponed for lack of space stay queued, so they are
+// processed again on resume.
+func (r *Runner) record(runCtx context.Context, path string, err error) {
+	switch {
+	case r.Journal == nil:
+	case err == nil:
+		r.Journal.Set(path, StateDone, nil)
+	case runCtx.Err() != nil || errors.Is(err, ErrNoSpace):
+		r.Journal.Set(path, StateQueued, nil)
+	default:
+		r.Journal.Set(path, StateFailed, err)
+	}
+}
@@ -0,0 +1,454 @@
+// Package server provides an HTTP API for queueing files for processing and
+// querying their status.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hranicka/mediatool/internal"
+)
+
+// Job states.
+const (
+	StateQueued    = "queued"
+	StateRunning   = "running"
+	StateDone      = "done"
+	StateFailed    = "failed"
+	StateCancelled = "cancelled"
+)
+
+var (
+	ErrQueueFull      = errors.New("queue is full")
+	ErrUnknownCommand = errors.New("unknown command")
+	ErrOutsideRoot    = errors.New("path is outside of the served root")
+	ErrDuplicate      = errors.New("file is queued or running already")
+)
+
+// Processor processes a single media file.
+type Processor interface {
+	Process(ctx context.Context, path string) error
+}
+
+// Job is a file queued for processing by a command.
+type Job struct {
+	ID       int64      `json:"id"`
+	Command  string     `json:"command"`
+	Path     string     `json:"path"`
+	State    string     `json:"state"`
+	Error    string     `json:"error,omitempty"`
+	Queued   time.Time  `json:"queued"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	cancel context.CancelFunc
+}
+
+// Server runs queued jobs with a fixed number of workers.
+type Server struct {
+	// Commands are the accepted commands.
+	Commands []string
+	// New returns the processor of the command for the file.
+	New  func(command string, path string) (Processor, error)
+	Exec internal.Executor
+	// Runner processes the files of jobs, its Jobs is the number of workers.
+	Runner *internal.Runner
+	// Root restricts queued and probed paths to the directory, empty allows
+	// any path.
+	Root string
+	// Walk configures the traversal of queued directories, its Ignores are
+	// set from IgnoreFiles and Ignores.
+	Walk internal.WalkOptions
+	// IgnoreFiles are names of ignore files applied in queued directories.
+	IgnoreFiles []string
+	// Ignores are gitignore patterns applied in queued directories.
+	Ignores []string
+	// QueueSize is the maximal number of queued jobs.
+	QueueSize int
+	// HistorySize is the number of finished jobs kept for listing.
+	HistorySize int
+
+	mu     sync.Mutex
+	queue  chan *Job
+	jobs   []*Job
+	nextID int64
+}
+
+// Run starts the workers and blocks until ctx is done and running jobs are
+// finished. No new jobs are started once walkCtx is done, running ones are
+// cancelled with runCtx.
+func (s *Server) Run(walkCtx context.Context, runCtx context.Context) {
+	s.init()
+
+	var wg sync.WaitGroup
+	for range max(s.Runner.Jobs, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-walkCtx.Done():
+					return
+				case job := <-s.queue:
+					s.run(runCtx, job)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *Server) init() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.queue == nil {
+		s.queue = make(chan *Job, max(s.QueueSize, 1))
+	}
+}
+
+// run processes the file of the job unless it was cancelled meanwhile.
+func (s *Server) run(runCtx context.Context, job *Job) {
+	ctx, cancel := context.WithCancel(runCtx)
+	defer cancel()
+
+	s.mu.Lock()
+	if job.State != StateQueued {
+		s.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	job.State, job.Started, job.cancel = StateRunning, &now, cancel
+	s.mu.Unlock()
+
+	var jobErr error
+	files, err := internal.StatFiles(job.Path)
+	if err == nil {
+		runner := *s.Runner
+		runner.Jobs = 1
+		runner.Command = job.Command
+		runner.Run(ctx, ctx, files, func(ctx context.Context, path string) error {
+			p, err := s.New(job.Command, path)
+			if err == nil {
+				err = p.Process(ctx, path)
+			}
+			jobErr = err
+			return err
+		})
+	} else {
+		jobErr = err
+		slog.Error("could not process", "file", job.Path, "error", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	finished := time.Now()
+	job.Finished, job.cancel = &finished, nil
+	switch {
+	case jobErr != nil && ctx.Err() != nil:
+		job.State = StateCancelled
+	case jobErr != nil:
+		job.State, job.Error = StateFailed, jobErr.Error()
+	default:
+		job.State = StateDone
+	}
+	s.prune()
+}
+
+// Enqueue queues media files at path, a file or a directory, for processing
+// by the command. Either all files are queued or none.
+func (s *Server) Enqueue(ctx context.Context, command string, path string) ([]Job, error) {
+	if !slices.Contains(s.Commands, command) {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCommand, command)
+	}
+	path, err := s.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []internal.File
+	if info.IsDir() {
+		ig, err := internal.NewIgnorer(path, s.IgnoreFiles, s.Ignores)
+		if err != nil {
+			return nil, err
+		}
+		opts := s.Walk
+		opts.Ignores = ig
+		// unreadable paths are logged, the readable files are queued
+		files, _ = internal.Collect(ctx, path, opts)
+		// followed symlinks may lead out of the root
+		files = slices.DeleteFunc(files, func(f internal.File) bool {
+			if _, err := s.resolve(f.Path); err != nil {
+				slog.Warn("skipping file", "file", f.Path, "error", err)
+				return true
+			}
+			return false
+		})
+	} else {
+		files = []internal.File{{Path: path, Info: info}}
+	}
+
+	s.init()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// a file processed by two workers at once would be swapped twice
+	active := s.active()
+	if !info.IsDir() && active[path] {
+		return nil, fmt.Errorf("%w: %s", ErrDuplicate, path)
+	}
+	files = slices.DeleteFunc(files, func(f internal.File) bool {
+		if active[f.Path] {
+			slog.Info("skipping file queued or running already", "file", f.Path)
+			return true
+		}
+		return false
+	})
+
+	if len(s.queue)+len(files) > cap(s.queue) {
+		return nil, fmt.Errorf("%w: %d files do not fit", ErrQueueFull, len(files))
+	}
+	jobs := make([]Job, 0, len(files))
+	for _, f := range files {
+		s.nextID++
+		job := &Job{ID: s.nextID, Command: command, Path: f.Path, State: StateQueued, Queued: time.Now()}
+		s.jobs = append(s.jobs, job)
+		s.queue <- job
+		jobs = append(jobs, *job)
+	}
+	slog.Info("jobs queued", "command", command, "path", path, "files", len(files))
+	return jobs, nil
+}
+
+// Jobs returns queued, running and finished jobs, optionally only those in
+// the given state.
+func (s *Server) Jobs(state string) []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var jobs []Job
+	for _, job := range s.jobs {
+		if state == "" || job.State == state {
+			jobs = append(jobs, *job)
+		}
+	}
+	return jobs
+}
+
+// Job returns the job with the id.
+func (s *Server) Job(id int64) (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if job := s.find(id); job != nil {
+		return *job, true
+	}
+	return Job{}, false
+}
+
+// Cancel removes a queued job from the queue or interrupts a running one.
+func (s *Server) Cancel(id int64) (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job := s.find(id)
+	if job == nil {
+		return Job{}, false
+	}
+	switch job.State {
+	case StateQueued:
+		now := time.Now()
+		job.State, job.Finished = StateCancelled, &now
+		s.prune()
+	case StateRunning:
+		job.cancel()
+	}
+	return *job, true
+}
+
+// active returns paths of queued and running jobs, must be called with s.mu
+// held.
+func (s *Server) active() map[string]bool {
+	paths := map[string]bool{}
+	for _, job := range s.jobs {
+		if job.State == StateQueued || job.State == StateRunning {
+			paths[job.Path] = true
+		}
+	}
+	return paths
+}
+
+func (s *Server) find(id int64) *Job {
+	for _, job := range s.jobs {
+		if job.ID == id {
+			return job
+		}
+	}
+	return nil
+}
+
+// prune drops finished jobs with the lowest ids over HistorySize, must be called with
+// s.mu held.
+func (s *Server) prune() {
+	finished := 0
+	for _, job := range s.jobs {
+		if job.Finished != nil {
+			finished++
+		}
+	}
+	jobs := s.jobs[:0]
+	for _, job := range s.jobs {
+		if job.Finished != nil && finished > s.HistorySize {
+			finished--
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	s.jobs = jobs
+}
+
+// resolve returns the absolute path with symlinks resolved, checking it is
+// within Root.
+func (s *Server) resolve(path string) (string, error) {
+	if path == "" {
+		return "", errors.New("path is required")
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if abs, err = filepath.EvalSymlinks(abs); err != nil {
+		return "", err
+	}
+	if s.Root != "" {
+		root, err := filepath.Abs(s.Root)
+		if err != nil {
+			return "", err
+		}
+		if root, err = filepath.EvalSymlinks(root); err != nil {
+			return "", err
+		}
+		if rel, err := filepath.Rel(root, abs); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", ErrOutsideRoot
+		}
+	}
+	return abs, nil
+}
+
+// Handler returns the HTTP API:
+//
+//	POST   /jobs        queue {"command": "...", "path": "..."}
+//	GET    /jobs        list jobs, ?state= filters them
+//	GET    /jobs/{id}   get a job
+//	DELETE /jobs/{id}   cancel a job
+//	GET    /probe       probe results of ?path=
+//	GET    /metrics     metrics of the runner, when it has them
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs", s.handleEnqueue)
+	mux.HandleFunc("GET /jobs", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string][]Job{"jobs": s.Jobs(r.URL.Query().Get("state"))})
+	})
+	mux.HandleFunc("GET /jobs/{id}", s.handleJob(s.Job))
+	mux.HandleFunc("DELETE /jobs/{id}", s.handleJob(s.Cancel))
+	mux.HandleFunc("GET /probe", s.handleProbe)
+	if s.Runner.Metrics != nil {
+		mux.Handle("GET /metrics", s.Runner.Metrics)
+	}
+	return mux
+}
+
+func (s *Server) handleEnqueue(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Command string `json:"command"`
+		Path    string `json:"path"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %v", err))
+		return
+	}
+
+	jobs, err := s.Enqueue(r.Context(), req.Command, req.Path)
+	switch {
+	case errors.Is(err, ErrQueueFull):
+		writeError(w, http.StatusServiceUnavailable, err)
+	case errors.Is(err, ErrOutsideRoot):
+		writeError(w, http.StatusForbidden, err)
+	case errors.Is(err, ErrDuplicate):
+		writeError(w, http.StatusConflict, err)
+	case errors.Is(err, os.ErrNotExist):
+		writeError(w, http.StatusNotFound, err)
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err)
+	default:
+		writeJSON(w, http.StatusAccepted, map[string][]Job{"jobs": jobs})
+	}
+}
+
+func (s *Server) handleJob(fn func(id int64) (Job, bool)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errors.New("invalid job id"))
+			return
+		}
+		job, ok := fn(id)
+		if !ok {
+			writeError(w, http.StatusNotFound, errors.New("job not found"))
+			return
+		}
+		writeJSON(w, http.StatusOK, job)
+	}
+}
+
+func (s *Server) handleProbe(w http.ResponseWriter, r *http.Request) {
+	path, err := s.resolve(r.URL.Query().Get("path"))
+	if errors.Is(err, ErrOutsideRoot) {
+		writeError(w, http.StatusForbidden, err)
+		return
+	} else if errors.Is(err, os.ErrNotExist) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	f, err := internal.Probe(r.Context(), s.Exec, path)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	f.IndexStreams()
+	writeJSON(w, http.StatusOK, f)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Debug("cannot write response", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hranicka/mediatool/internal"
+	"github.com/hranicka/mediatool/internal/exectest"
+)
+
+// blocking is a processor running until released or cancelled.
+type blocking struct {
+	started chan string
+	release chan struct{}
+}
+
+func (p *blocking) Process(ctx context.Context, path string) error {
+	p.started <- path
+	select {
+	case <-p.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestServer(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.mkv", "b.mkv", "c.mkv", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := &blocking{started: make(chan string), release: make(chan struct{})}
+	s := &Server{
+		Commands: []string{"ac3"},
+		New: func(command string, path string) (Processor, error) {
+			return p, nil
+		},
+		Exec: &exectest.Recorder{Outputs: map[string][]byte{
+			"ffprobe": []byte(`{"streams": [{"index": 0, "codec_type": "audio", "codec_name": "dts"}], "format": {"duration": "10.0"}}`),
+		}},
+		Runner:      &internal.Runner{Jobs: 1, Metrics: internal.NewMetrics()},
+		Root:        dir,
+		QueueSize:   3,
+		HistorySize: 2,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx, ctx)
+
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	do := func(method string, path string, body any, status int, v any) {
+		t.Helper()
+		var r bytes.Buffer
+		if body != nil {
+			if err := json.NewEncoder(&r).Encode(body); err != nil {
+				t.Fatal(err)
+			}
+		}
+		req, err := http.NewRequest(method, ts.URL+path, &r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Fatalf("%s %s: got status %d, want %d", method, path, resp.StatusCode, status)
+		}
+		if v != nil {
+			if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	type jobs struct {
+		Jobs []Job `json:"jobs"`
+	}
+	waitState := func(id int64, state string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			var job Job
+			do(http.MethodGet, "/jobs/"+strconv.FormatInt(id, 10), nil, http.StatusOK, &job)
+			if job.State == state {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("job %d: got state %s, want %s", id, job.State, state)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	do(http.MethodPost, "/jobs", map[string]string{"command": "hevc", "path": dir}, http.StatusBadRequest, nil)
+	do(http.MethodPost, "/jobs", map[string]string{"command": "ac3", "path": "/"}, http.StatusForbidden, nil)
+	do(http.MethodPost, "/jobs", map[string]string{"command": "ac3", "path": filepath.Join(dir, "x.mkv")}, http.StatusNotFound, nil)
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "o.mkv"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// a symlink within the root must not lead out of it
+	if runtime.GOOS != "windows" {
+		link := filepath.Join(dir, "link.mkv")
+		if err := os.Symlink(filepath.Join(outside, "o.mkv"), link); err != nil {
+			t.Fatal(err)
+		}
+		do(http.MethodPost, "/jobs", map[string]string{"command": "ac3", "path": link}, http.StatusForbidden, nil)
+		do(http.MethodGet, "/probe?path="+link, nil, http.StatusForbidden, nil)
+		if err := os.Remove(link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var queued jobs
+	do(http.MethodPost, "/jobs", map[string]string{"command": "ac3", "path": dir}, http.StatusAccepted, &queued)
+	if len(queued.Jobs) != 3 || queued.Jobs[0].Path != filepath.Join(dir, "a.mkv") {
+		t.Fatalf("got queued jobs %+v", queued.Jobs)
+	}
+
+	// the first job is running, the rest fills the queue
+	if path := <-p.started; path != filepath.Join(dir, "a.mkv") {
+		t.Fatalf("got started %s", path)
+	}
+	// files queued or running already are refused or left out
+	do(http.MethodPost, "/jobs", map[string]string{"command": "ac3", "path": filepath.Join(dir, "a.mkv")}, http.StatusConflict, nil)
+	do(http.MethodPost, "/jobs", map[string]string{"command": "ac3", "path": filepath.Join(dir, "b.mkv")}, http.StatusConflict, nil)
+	var none jobs
+	do(http.MethodPost, "/jobs", map[string]string{"command": "ac3", "path": dir}, http.StatusAccepted, &none)
+	if len(none.Jobs) != 0 {
+		t.Fatalf("got duplicate jobs %+v", none.Jobs)
+	}
+
+	more := filepath.Join(dir, "more")
+	if err := os.Mkdir(more, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"d.mkv", "e.mkv"} {
+		if err := os.WriteFile(filepath.Join(more, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	do(http.MethodPost, "/jobs", map[string]string{"command": "ac3", "path": more}, http.StatusServiceUnavailable, nil)
+
+	do(http.MethodDelete, "/jobs/2", nil, http.StatusOK, nil)
+	waitState(2, StateCancelled)
+	do(http.MethodDelete, "/jobs/1", nil, http.StatusOK, nil)
+	waitState(1, StateCancelled)
+
+	if path := <-p.started; path != filepath.Join(dir, "c.mkv") {
+		t.Fatalf("got started %s", path)
+	}
+	p.release <- struct{}{}
+	waitState(3, StateDone)
+	do(http.MethodGet, "/jobs/9", nil, http.StatusNotFound, nil)
+
+	// only the last two finished jobs are kept
+	var list jobs
+	do(http.MethodGet, "/jobs", nil, http.StatusOK, &list)
+	if len(list.Jobs) != 2 || list.Jobs[0].ID != 2 || list.Jobs[1].ID != 3 {
+		t.Fatalf("got jobs %+v", list.Jobs)
+	}
+	do(http.MethodGet, "/jobs?state=done", nil, http.StatusOK, &list)
+	if len(list.Jobs) != 1 || list.Jobs[0].ID != 3 {
+		t.Fatalf("got done jobs %+v", list.Jobs)
+	}
+
+	resp, err := http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(metrics), `mediatool_files_scanned_total{processor="ac3"} 1`) {
+		t.Errorf("unexpected metrics:\n%s", metrics)
+	}
+
+	var f internal.FFprobe
+	do(http.MethodGet, "/probe?path="+filepath.Join(dir, "a.mkv"), nil, http.StatusOK, &f)
+	if len(f.Streams) != 1 || f.Streams[0].CodecName != "dts" {
+		t.Fatalf("got probe %+v", f)
+	}
+}
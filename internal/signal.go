@@ -0,0 +1,49 @@
+package internal
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// NotifyContext returns two contexts bound to process signals.
+//
+// The walk context is cancelled on the first SIGINT, so no further files are
+// started, but the file being processed is finished. The run context is
+// cancelled on the second SIGINT or on any SIGTERM, which kills the running
+// ffmpeg and removes its temporary output.
+func NotifyContext(parent context.Context) (walk context.Context, run context.Context, stop func()) {
+	run, cancelRun := context.WithCancel(parent)
+	walk, cancelWalk := context.WithCancel(run)
+
+	sig := make(chan os.Signal, 2)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case s := <-sig:
+				if s == os.Interrupt && walk.Err() == nil {
+					slog.Warn("interrupt received, finishing current file (interrupt again to abort)")
+					cancelWalk()
+					continue
+				}
+				slog.Warn("interrupt received, aborting current file", "signal", s.String())
+				cancelRun()
+				return
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return walk, run, func() {
+		signal.Stop(sig)
+		close(done)
+		cancelWalk()
+		cancelRun()
+	}
+}
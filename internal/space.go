@@ -0,0 +1,179 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// CheckSpace enables the free space preflight of conversions.
+	CheckSpace = true
+	// SpaceReserve is the free space in bytes which must be left on the file
+	// system after a conversion output is written.
+	SpaceReserve int64 = 1 << 30
+
+	// ErrNoSpace is returned for files whose conversion would not fit.
+	ErrNoSpace = errors.New("not enough free space")
+
+	errSpaceUnsupported = errors.New("free space check not supported")
+
+	space = newSpaceTracker()
+)
+
+// spaceTracker accounts outputs of running conversions, which are not written
+// yet but will take the free space.
+type spaceTracker struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending map[uint64]int64
+	running map[uint64]int
+}
+
+func newSpaceTracker() *spaceTracker {
+	s := &spaceTracker{pending: make(map[uint64]int64), running: make(map[uint64]int)}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// reserve waits until the output of estimated size fits on the file
+// system of src and reserves the space until release is called. The file is
+// deferred while other conversions on the same file system are running, and
+// rejected with ErrNoSpace when it does not fit even without them.
+func (s *spaceTracker) reserve(ctx context.Context, src string, estimate int64) (release func(), err error) {
+	if !CheckSpace {
+		return func() {}, nil
+	}
+
+	dir := filepath.Dir(src)
+	free, dev, err := freeSpace(dir)
+	if errors.Is(err, errSpaceUnsupported) {
+		return func() {}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot get free space: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// wake up on cancellation
+	stop := context.AfterFunc(ctx, func() {
+		s.mu.Lock()
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	})
+	defer stop()
+
+	for {
+		available := int64(free) - s.pending[dev] - SpaceReserve
+		if estimate <= available {
+			break
+		}
+		if s.running[dev] == 0 {
+			return nil, fmt.Errorf("%w: output needs %s, %s available with %s reserve", ErrNoSpace, FormatSize(estimate), FormatSize(max(available, 0)), FormatSize(SpaceReserve))
+		}
+
+		slog.InfoContext(ctx, "not enough free space, waiting for running conversions", "file", src, "estimate", FormatSize(estimate), "available", FormatSize(max(available, 0)))
+		s.cond.Wait()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		// outputs of finished conversions are on the disk now
+		s.mu.Unlock()
+		free, _, err = freeSpace(dir)
+		s.mu.Lock()
+		if err != nil {
+			return nil, fmt.Errorf("cannot get free space: %v", err)
+		}
+	}
+
+	s.pending[dev] += estimate
+	s.running[dev]++
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.pending[dev] -= estimate
+		s.running[dev]--
+		s.cond.Broadcast()
+	}, nil
+}
+
+// EstimateOutputSize returns the expected output size of a conversion of the
+// probed file. Of the affected streams, those in bitrates get the new bitrate
+// in bits per second, zero removes the stream. Their source bitrate is taken
+// from streams, so estimates of missing ones made by detection are used. The
+// size of streams of unknown bitrate cannot be subtracted, so the estimate
+// errs on the larger side.
+func EstimateOutputSize(f *FFprobe, streams []Stream, bitrates map[int]int64) int64 {
+	size, _ := strconv.ParseInt(f.Format.Size, 10, 64)
+	seconds := ParseSeconds(f.Format.Duration).Seconds()
+
+	for _, s := range streams {
+		br, ok := bitrates[s.Index]
+		if !ok {
+			continue
+		}
+		if old, _ := strconv.ParseInt(s.BitRate, 10, 64); old > 0 {
+			size -= int64(float64(old) * seconds / 8)
+		}
+		size += int64(float64(br) * seconds / 8)
+	}
+	return max(size, 0)
+}
+
+// ParseBitRate parses ffmpeg bitrates like 640k or 8M to bits per second.
+func ParseBitRate(s string) (int64, error) {
+	return parseUnits(s, 1000)
+}
+
+// ParseSize parses sizes like 512M or 10G to bytes.
+func ParseSize(s string) (int64, error) {
+	return parseUnits(s, 1024)
+}
+
+func parseUnits(s string, base int64) (int64, error) {
+	s = strings.TrimSpace(s)
+	mult := int64(1)
+	if s != "" {
+		switch strings.ToUpper(s[len(s)-1:]) {
+		case "K":
+			mult = base
+		case "M":
+			mult = base * base
+		case "G":
+			mult = base * base * base
+		case "T":
+			mult = base * base * base * base
+		}
+		if mult > 1 {
+			s = s[:len(s)-1]
+		}
+	}
+
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	return int64(v * float64(mult)), nil
+}
+
+// FormatSize formats bytes in binary units.
+func FormatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit && exp < 3; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%c", float64(size)/float64(div), "KMGT"[exp])
+}
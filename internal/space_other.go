@@ -0,0 +1,7 @@
+//go:build !(linux || darwin || freebsd || openbsd || dragonfly)
+
+package internal
+
+func freeSpace(_ string) (free uint64, dev uint64, err error) {
+	return 0, 0, errSpaceUnsupported
+}
@@ -0,0 +1,96 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEstimateOutputSize(t *testing.T) {
+	f := &FFprobe{
+		Streams: []Stream{
+			{Index: 0, BitRate: "8000000"},
+			{Index: 1, BitRate: "1536000"},
+			{Index: 2},
+		},
+		Format: Format{Size: "1000000000", Duration: "800"},
+	}
+
+	tests := []struct {
+		name     string
+		bitrates map[int]int64
+		want     int64
+	}{
+		{name: "copy", want: 1000000000},
+		{name: "convert audio", bitrates: map[int]int64{1: 640000}, want: 1000000000 - 153600000 + 64000000},
+		{name: "remove video", bitrates: map[int]int64{0: 0}, want: 200000000},
+		{name: "unknown bitrate", bitrates: map[int]int64{2: 640000}, want: 1064000000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EstimateOutputSize(f, f.Streams, tt.bitrates); got != tt.want {
+				t.Errorf("expected %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for s, want := range map[string]int64{"0": 0, "512": 512, "10K": 10240, "1.5G": 3 << 29, "2t": 2 << 40} {
+		if got, err := ParseSize(s); err != nil || got != want {
+			t.Errorf("%s: expected %d, got %d, %v", s, want, got, err)
+		}
+	}
+	if _, err := ParseSize("lots"); err == nil {
+		t.Error("invalid size accepted")
+	}
+	if got, _ := ParseBitRate("640k"); got != 640000 {
+		t.Errorf("expected 640000, got %d", got)
+	}
+}
+
+func TestReserveSpace(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "movie.mkv")
+	free, _, err := freeSpace(dir)
+	if errors.Is(err, errSpaceUnsupported) {
+		t.Skip(err)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(reserve int64) { SpaceReserve = reserve }(SpaceReserve)
+	SpaceReserve = 0
+	s := newSpaceTracker()
+	ctx := context.Background()
+
+	if _, err := s.reserve(ctx, src, int64(free)*2); !errors.Is(err, ErrNoSpace) {
+		t.Fatalf("expected ErrNoSpace, got %v", err)
+	}
+
+	// the second output fits only after the first one is done
+	release, err := s.reserve(ctx, src, int64(free)*6/10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reserved := make(chan struct{})
+	go func() {
+		release, err := s.reserve(ctx, src, int64(free)*6/10)
+		if err != nil {
+			t.Error(err)
+		} else {
+			release()
+		}
+		close(reserved)
+	}()
+
+	select {
+	case <-reserved:
+		t.Fatal("reservation not deferred")
+	case <-time.After(50 * time.Millisecond):
+	}
+	release()
+	<-reserved
+}
@@ -0,0 +1,21 @@
+//go:build linux || darwin || freebsd || openbsd || dragonfly
+
+package internal
+
+import (
+	"syscall"
+)
+
+// freeSpace returns bytes available to unprivileged users on the file system
+// of dir and the id of the device.
+func freeSpace(dir string) (free uint64, dev uint64, err error) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(dir, &fs); err != nil {
+		return 0, 0, err
+	}
+	var st syscall.Stat_t
+	if err := syscall.Stat(dir, &st); err != nil {
+		return 0, 0, err
+	}
+	return uint64(fs.Bavail) * uint64(fs.Bsize), uint64(st.Dev), nil
+}
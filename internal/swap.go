@@ -0,0 +1,287 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Resolution is the outcome of resolving leftovers of an interrupted
+// conversion or swap.
+type Resolution string
+
+const (
+	// ResolvedNone means there was nothing to resolve.
+	ResolvedNone Resolution = "none"
+	// ResolvedCompleted means an interrupted swap was finished.
+	ResolvedCompleted Resolution = "completed"
+	// ResolvedRestored means the original file was put back.
+	ResolvedRestored Resolution = "restored"
+	// ResolvedDiscarded means an unfinished conversion was removed.
+	ResolvedDiscarded Resolution = "discarded"
+	// ResolvedKept means the original kept after conversion was left alone.
+	ResolvedKept Resolution = "kept"
+	// ResolvedDeleted means the original kept after conversion was removed.
+	ResolvedDeleted Resolution = "deleted"
+)
+
+// swapIntent is written next to the source before the converted file is
+// swapped into place. Its presence means the converted file is complete, so
+// the swap can always be finished.
+type swapIntent struct {
+	Src string `json:"src"`
+	// Dst is the path of the converted file when it is remuxed into another
+	// container, empty means Src.
+	Dst string `json:"dst,omitempty"`
+	Tmp string `json:"tmp"`
+	Old string `json:"old"`
+	Del bool   `json:"del"`
+	// Backup is the directory the original is moved to, see BackupDir.
+	Backup string    `json:"backup,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// IntentPath returns the path of the swap intent log of src.
+func IntentPath(src string) string {
+	return src + ".swap"
+}
+
+// swap replaces src by the converted file tmp moved to dst, keeping the
+// original as OldPath(src) or in BackupDir, or removing it when del is set.
+// Metadata of the original is copied first, then the intent is logged, so a
+// crash at any point can be resolved by ResolveSwap.
+func swap(ctx context.Context, src string, dst string, tmp string, del bool) error {
+	preserveMetadata(ctx, src, tmp)
+	if err := syncFile(tmp); err != nil {
+		return fmt.Errorf("cannot sync converted file: %v", err)
+	}
+
+	intent := swapIntent{Src: src, Tmp: tmp, Old: OldPath(src), Del: del, Backup: BackupDir, Time: time.Now()}
+	if dst != src {
+		intent.Dst = dst
+	}
+	if err := writeIntent(intent); err != nil {
+		return fmt.Errorf("cannot write swap intent: %v", err)
+	}
+
+	if err := os.Rename(src, intent.Old); err != nil {
+		return fmt.Errorf("cannot rename source file: %v", err)
+	}
+	if err := os.Rename(tmp, intent.dst()); err != nil {
+		return fmt.Errorf("cannot rename converted file: %v", err)
+	}
+	return finishSwap(intent)
+}
+
+// dst returns the path of the converted file.
+func (intent swapIntent) dst() string {
+	if intent.Dst != "" {
+		return intent.Dst
+	}
+	return intent.Src
+}
+
+// finishSwap syncs the renames, removes or backs up the original if requested
+// and drops the intent log.
+func finishSwap(intent swapIntent) error {
+	InvalidateProbe(intent.Src)
+	InvalidateProbe(intent.dst())
+	syncDir(filepath.Dir(intent.Src))
+
+	switch {
+	case !exists(intent.Old):
+	case intent.Del:
+		if err := os.Remove(intent.Old); err != nil {
+			return fmt.Errorf("cannot delete source file: %v", err)
+		}
+	case intent.Backup != "":
+		if err := backupOriginal(intent.Backup, intent.Src, intent.Old); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(IntentPath(intent.Src)); err != nil {
+		return fmt.Errorf("cannot remove swap intent: %v", err)
+	}
+	syncDir(filepath.Dir(intent.Src))
+	return nil
+}
+
+// ResolveSwap resolves leftovers of src. A logged swap is finished, or rolled
+// back when the converted file got lost. Without an intent log, a temporary
+// file is an unfinished conversion and is removed, and a missing source is
+// restored from its original unless it was remuxed. An original kept next to
+// the source is removed only with delOld. With dryRun set, the resolution is only reported.
+func ResolveSwap(src string, delOld bool, dryRun bool) (Resolution, error) {
+	tmp, old := TempPath(src), OldPath(src)
+	hasSrc, hasTmp, hasOld := exists(src), exists(tmp), exists(old)
+
+	intent, err := readIntent(src)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return ResolvedNone, fmt.Errorf("cannot read swap intent: %v", err)
+	}
+
+	if intent != nil {
+		dst := intent.dst()
+		switch {
+		case hasTmp:
+			if !dryRun {
+				if hasSrc {
+					if err := os.Rename(src, old); err != nil {
+						return ResolvedNone, fmt.Errorf("cannot rename source file: %v", err)
+					}
+				}
+				if err := os.Rename(tmp, dst); err != nil {
+					return ResolvedNone, fmt.Errorf("cannot rename converted file: %v", err)
+				}
+			}
+		case exists(dst):
+			// renamed already, only the cleanup is missing
+		case hasOld:
+			// converted file lost, put the original back
+			if dryRun {
+				return ResolvedRestored, nil
+			}
+			if err := os.Rename(old, src); err != nil {
+				return ResolvedNone, fmt.Errorf("cannot restore source file: %v", err)
+			}
+			if err := os.Remove(IntentPath(src)); err != nil {
+				return ResolvedNone, fmt.Errorf("cannot remove swap intent: %v", err)
+			}
+			InvalidateProbe(src)
+			return ResolvedRestored, nil
+		default:
+			return ResolvedNone, errors.New("neither source, converted nor original file exists")
+		}
+
+		if dryRun {
+			return ResolvedCompleted, nil
+		}
+		return ResolvedCompleted, finishSwap(*intent)
+	}
+
+	switch {
+	case hasTmp:
+		if !dryRun {
+			if err := os.Remove(tmp); err != nil {
+				return ResolvedNone, fmt.Errorf("cannot remove unfinished conversion: %v", err)
+			}
+		}
+		return ResolvedDiscarded, nil
+	case hasOld && !hasSrc && !remuxed(src):
+		if !dryRun {
+			if err := os.Rename(old, src); err != nil {
+				return ResolvedNone, fmt.Errorf("cannot restore source file: %v", err)
+			}
+			InvalidateProbe(src)
+		}
+		return ResolvedRestored, nil
+	case hasOld && delOld:
+		if !dryRun {
+			if err := os.Remove(old); err != nil {
+				return ResolvedNone, fmt.Errorf("cannot delete source file: %v", err)
+			}
+		}
+		return ResolvedDeleted, nil
+	case hasOld:
+		return ResolvedKept, nil
+	}
+	return ResolvedNone, nil
+}
+
+// remuxed reports whether src was converted into another container, so a
+// missing src is expected.
+func remuxed(src string) bool {
+	dst := remuxPath(src)
+	return dst != src && exists(dst)
+}
+
+// LeftoverSource returns the source file a leftover of a conversion or swap
+// belongs to, or false when path is not a leftover. Sources are files of
+// MediaExtensions or of any known container, so leftovers of runs with other
+// extensions are found as well.
+func LeftoverSource(path string) (string, bool) {
+	if src, ok := strings.CutSuffix(path, ".tmp"+strings.ToLower(filepath.Ext(path))); ok && isLeftoverSource(src) && TempPath(src) == path {
+		return src, true
+	}
+	for _, suffix := range []string{".old", ".swap"} {
+		if src, ok := strings.CutSuffix(path, suffix); ok && isLeftoverSource(src) {
+			return src, true
+		}
+	}
+	return "", false
+}
+
+// isLeftoverSource reports whether path may be the source of a conversion.
+func isLeftoverSource(path string) bool {
+	return isMediaName(path) || ContainerOf(path) != nil
+}
+
+func writeIntent(intent swapIntent) error {
+	data, err := json.Marshal(intent)
+	if err != nil {
+		return err
+	}
+
+	path := IntentPath(intent.Src)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(path))
+	return nil
+}
+
+func readIntent(src string) (*swapIntent, error) {
+	data, err := os.ReadFile(IntentPath(src))
+	if err != nil {
+		return nil, err
+	}
+
+	intent := &swapIntent{}
+	if err := json.Unmarshal(data, intent); err != nil {
+		return nil, err
+	}
+	if intent.Src != src {
+		return nil, fmt.Errorf("intent belongs to %s", intent.Src)
+	}
+	return intent, nil
+}
+
+func syncFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// syncDir makes renames in dir durable. Not every file system supports it,
+// so failures are logged only.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err == nil {
+		err = d.Sync()
+		_ = d.Close()
+	}
+	if err != nil {
+		slog.Debug("cannot sync directory", "dir", dir, "error", err)
+	}
+}
@@ -0,0 +1,164 @@
+package internal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSwap(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "movie.mkv")
+	for path, content := range map[string]string{src: "src", TempPath(src): "tmp"} {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := swap(context.Background(), src, src, TempPath(src), false); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(src); string(data) != "tmp" {
+		t.Errorf("expected converted file at source path, got %s", data)
+	}
+	if data, _ := os.ReadFile(OldPath(src)); string(data) != "src" {
+		t.Errorf("expected original kept, got %s", data)
+	}
+	if exists(IntentPath(src)) {
+		t.Error("swap intent left")
+	}
+}
+
+func TestResolveSwap(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   []string
+		delOld  bool
+		res     Resolution
+		content string
+		left    []string
+	}{
+		{name: "logged not renamed", files: []string{"intent", "src", "tmp"}, res: ResolvedCompleted, content: "tmp", left: []string{"old"}},
+		{name: "logged source renamed", files: []string{"intent", "tmp", "old"}, res: ResolvedCompleted, content: "tmp", left: []string{"old"}},
+		{name: "logged renamed", files: []string{"intent", "src", "old"}, res: ResolvedCompleted, content: "src", left: []string{"old"}},
+		{name: "logged delete", files: []string{"intent-del", "tmp", "old"}, res: ResolvedCompleted, content: "tmp"},
+		{name: "logged converted lost", files: []string{"intent", "old"}, res: ResolvedRestored, content: "old"},
+		{name: "unfinished conversion", files: []string{"src", "tmp"}, res: ResolvedDiscarded, content: "src"},
+		{name: "source lost", files: []string{"old"}, res: ResolvedRestored, content: "old"},
+		{name: "kept original", files: []string{"src", "old"}, res: ResolvedKept, content: "src", left: []string{"old"}},
+		{name: "delete original", files: []string{"src", "old"}, delOld: true, res: ResolvedDeleted, content: "src"},
+		{name: "nothing", files: []string{"src"}, res: ResolvedNone, content: "src"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := filepath.Join(t.TempDir(), "movie.mkv")
+			paths := map[string]string{"src": src, "tmp": TempPath(src), "old": OldPath(src)}
+			for _, f := range tt.files {
+				switch f {
+				case "intent", "intent-del":
+					if err := writeIntent(swapIntent{Src: src, Tmp: TempPath(src), Old: OldPath(src), Del: f == "intent-del"}); err != nil {
+						t.Fatal(err)
+					}
+				default:
+					if err := os.WriteFile(paths[f], []byte(f), 0o644); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			// dry run reports the same resolution without touching files
+			if res, err := ResolveSwap(src, tt.delOld, true); err != nil || res != tt.res {
+				t.Fatalf("unexpected dry run resolution: %s, %v", res, err)
+			}
+			for _, f := range tt.files {
+				if f != "intent" && f != "intent-del" && !exists(paths[f]) {
+					t.Fatalf("dry run removed %s", f)
+				}
+			}
+
+			res, err := ResolveSwap(src, tt.delOld, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res != tt.res {
+				t.Errorf("expected %s, got %s", tt.res, res)
+			}
+
+			data, err := os.ReadFile(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.content {
+				t.Errorf("expected %s at source path, got %s", tt.content, data)
+			}
+			left := map[string]bool{}
+			for _, f := range tt.left {
+				left[f] = true
+			}
+			for _, f := range []string{"tmp", "old"} {
+				if exists(paths[f]) != left[f] {
+					t.Errorf("unexpected %s file presence: %t", f, exists(paths[f]))
+				}
+			}
+			if exists(IntentPath(src)) {
+				t.Error("swap intent left")
+			}
+		})
+	}
+}
+
+func TestSwapPreservesMetadata(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "movie.mkv")
+	tmp := TempPath(src)
+	for _, path := range []string{src, tmp} {
+		if err := os.WriteFile(path, []byte(path), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chmod(src, 0o664); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(src, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := swap(context.Background(), src, src, tmp, true); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o664 {
+		t.Errorf("expected mode 0664, got %v", info.Mode().Perm())
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Errorf("expected mtime %v, got %v", mtime, info.ModTime())
+	}
+}
+
+func TestLeftoverSource(t *testing.T) {
+	tests := []struct {
+		path string
+		src  string
+	}{
+		{path: "/m/movie.mkv.tmp.mkv", src: "/m/movie.mkv"},
+		{path: "/m/movie.avi.tmp.avi", src: "/m/movie.avi"},
+		{path: "/m/movie.MOV.tmp.mov", src: "/m/movie.MOV"},
+		{path: "/m/movie.ts.old", src: "/m/movie.ts"},
+		{path: "/m/movie.wmv.swap", src: "/m/movie.wmv"},
+		{path: "/m/movie.mkv"},
+		{path: "/m/movie.mkv.tmp.mp4"},
+		{path: "/m/notes.txt.old"},
+	}
+	for _, tt := range tests {
+		src, ok := LeftoverSource(tt.path)
+		if src != tt.src || ok != (tt.src != "") {
+			t.Errorf("%s: expected %q, got %q, %v", tt.path, tt.src, src, ok)
+		}
+	}
+}
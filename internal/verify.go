@@ -0,0 +1,138 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"time"
+)
+
+var (
+	// VerifyOutput enables checks of converted files before they replace the
+	// originals.
+	VerifyOutput = true
+	// VerifyTolerance is the maximal difference of source and output duration.
+	VerifyTolerance = 2 * time.Second
+	// VerifyDecode additionally decodes the whole output, which takes about as
+	// long as the conversion itself.
+	VerifyDecode = false
+)
+
+// OutputStream is a stream expected in the converted file.
+type OutputStream struct {
+	Type string `json:"type"`
+	// Codec is checked only when set.
+	Codec    string `json:"codec,omitempty"`
+	Language string `json:"lang,omitempty"`
+}
+
+// ExpectStreams returns streams of the probed file expected in the output.
+// Removed streams are dropped, the ones in codecs are expected in the new
+// codec and the rest in their own. Unless mapAll is set, only video, audio and
+// subtitle streams are kept.
+func ExpectStreams(f *FFprobe, mapAll bool, removed []Stream, codecs map[int]string) []OutputStream {
+	var out []OutputStream
+	for _, s := range f.Streams {
+		if !mapAll && s.CodecType != TypeVideo && s.CodecType != TypeAudio && s.CodecType != TypeSubtitles {
+			continue
+		}
+		if containsStream(removed, s) {
+			continue
+		}
+		codec, ok := codecs[s.Index]
+		if !ok {
+			codec = s.CodecName
+		}
+		out = append(out, OutputStream{Type: s.CodecType, Codec: codec, Language: s.Tags.Language})
+	}
+	return out
+}
+
+// StreamCodecs returns codecs for ExpectStreams with all streams converted to
+// the codec.
+func StreamCodecs(streams []Stream, codec string) map[int]string {
+	codecs := make(map[int]string, len(streams))
+	for _, s := range streams {
+		codecs[s.Index] = codec
+	}
+	return codecs
+}
+
+// sameLanguage compares language tags, a missing tag is written as und by
+// muxers.
+func sameLanguage(a string, b string) bool {
+	if a == "" {
+		a = "und"
+	}
+	if b == "" {
+		b = "und"
+	}
+	return a == b
+}
+
+func containsStream(streams []Stream, s Stream) bool {
+	for _, c := range streams {
+		if c.Index == s.Index {
+			return true
+		}
+	}
+	return false
+}
+
+// verify checks the converted file dst matches the entry, so it can replace
+// the source.
+func verify(ctx context.Context, e Executor, entry *PlanEntry, dst string) error {
+	if !VerifyOutput {
+		return nil
+	}
+
+	info, err := os.Stat(dst)
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return errors.New("output is empty")
+	}
+
+	f, err := probe(ctx, e, dst)
+	if err != nil {
+		return err
+	}
+
+	if want := ParseSeconds(entry.Duration); want > 0 {
+		got := ParseSeconds(f.Format.Duration)
+		if diff := time.Duration(math.Abs(float64(got - want))); diff > VerifyTolerance {
+			return fmt.Errorf("output duration %s differs from %s", got, want)
+		}
+	}
+
+	if entry.Output != nil {
+		if len(f.Streams) != len(entry.Output) {
+			return fmt.Errorf("expected %d output streams, got %d", len(entry.Output), len(f.Streams))
+		}
+		for i, want := range entry.Output {
+			got := f.Streams[i]
+			switch {
+			case got.CodecType != want.Type:
+				return fmt.Errorf("stream %d: expected %s, got %s", i, want.Type, got.CodecType)
+			case want.Codec != "" && got.CodecName != want.Codec:
+				return fmt.Errorf("stream %d: expected codec %s, got %s", i, want.Codec, got.CodecName)
+			case !sameLanguage(got.Tags.Language, want.Language):
+				return fmt.Errorf("stream %d: expected language %q, got %q", i, want.Language, got.Tags.Language)
+			}
+		}
+	}
+
+	if VerifyDecode {
+		slog.DebugContext(ctx, "decoding output", "file", entry.File, "tmp", dst)
+		if _, err := e.Output(ctx, FFmpegPath, "-v", "error", "-xerror", "-i", dst, "-map", "0", "-f", "null", "-"); err != nil {
+			return fmt.Errorf("cannot decode output: %v", err)
+		}
+	}
+
+	slog.DebugContext(ctx, "output verified", "file", entry.File, "tmp", dst)
+	return nil
+}
@@ -0,0 +1,129 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// probeExecutor returns the ffprobe output of f.
+type probeExecutor struct {
+	f *FFprobe
+}
+
+func (e probeExecutor) Output(context.Context, string, ...string) ([]byte, error) {
+	return json.Marshal(e.f)
+}
+
+func (e probeExecutor) Stream(context.Context, func(r io.Reader) error, string, ...string) error {
+	return nil
+}
+
+// convertExecutor writes the output file of ffmpeg and returns the ffprobe
+// output of f.
+type convertExecutor struct {
+	probeExecutor
+}
+
+func (e convertExecutor) Stream(_ context.Context, _ func(r io.Reader) error, _ string, arg ...string) error {
+	return os.WriteFile(arg[len(arg)-1], []byte("converted"), 0o644)
+}
+
+func TestExecuteVerifyLimits(t *testing.T) {
+	SetLimits(1, 0, 0)
+	defer SetLimits(0, 0, 0)
+
+	src := filepath.Join(t.TempDir(), "movie.mkv")
+	if err := os.WriteFile(src, []byte("movie"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f := &FFprobe{Streams: []Stream{{Index: 0, CodecType: TypeVideo, CodecName: CodecH264}}}
+	entry := &PlanEntry{
+		File:   src,
+		Class:  Cheap,
+		Args:   []string{"-i", src, TempPath(src)},
+		Output: ExpectStreams(f, false, nil, nil),
+	}
+
+	// verification probes the output while the conversion holds no slot
+	errc := make(chan error, 1)
+	go func() {
+		errc <- Execute(context.Background(), convertExecutor{probeExecutor{f: f}}, entry, false, true)
+	}()
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("execute blocked on verification")
+	}
+	if data, err := os.ReadFile(src); err != nil || string(data) != "converted" {
+		t.Errorf("file not replaced: %q, %v", data, err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	src := &FFprobe{
+		Streams: []Stream{
+			{Index: 0, CodecType: TypeVideo, CodecName: CodecH264},
+			{Index: 1, CodecType: TypeAudio, CodecName: CodecDTS, Tags: Tags{Language: "eng"}},
+			{Index: 2, CodecType: TypeSubtitles, CodecName: "subrip", Tags: Tags{Language: "ger"}},
+			{Index: 3, CodecType: "attachment"},
+		},
+		Format: Format{Duration: "100.000000"},
+	}
+	entry := &PlanEntry{
+		Duration: src.Format.Duration,
+		Output:   ExpectStreams(src, false, []Stream{src.Streams[2]}, StreamCodecs(src.Streams[1:2], CodecAC3)),
+	}
+
+	good := func() *FFprobe {
+		return &FFprobe{
+			Streams: []Stream{
+				{Index: 0, CodecType: TypeVideo, CodecName: CodecH264},
+				{Index: 1, CodecType: TypeAudio, CodecName: CodecAC3, Tags: Tags{Language: "eng"}},
+			},
+			Format: Format{Duration: "101.000000"},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(f *FFprobe)
+		err    bool
+	}{
+		{name: "valid", modify: func(f *FFprobe) {}},
+		{name: "duration", modify: func(f *FFprobe) { f.Format.Duration = "60.0" }, err: true},
+		{name: "missing stream", modify: func(f *FFprobe) { f.Streams = f.Streams[:1] }, err: true},
+		{name: "codec", modify: func(f *FFprobe) { f.Streams[1].CodecName = CodecDTS }, err: true},
+		{name: "language", modify: func(f *FFprobe) { f.Streams[1].Tags.Language = "" }, err: true},
+	}
+
+	dst := filepath.Join(t.TempDir(), "movie.mkv.tmp.mkv")
+	if err := os.WriteFile(dst, []byte("movie"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := good()
+			tt.modify(f)
+			err := verify(context.Background(), probeExecutor{f: f}, entry, dst)
+			if (err != nil) != tt.err {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+
+	empty := filepath.Join(t.TempDir(), "empty.mkv.tmp.mkv")
+	if err := os.WriteFile(empty, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(context.Background(), probeExecutor{f: good()}, entry, empty); err == nil {
+		t.Error("empty output not refused")
+	}
+}
@@ -0,0 +1,159 @@
+package internal
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// WalkOptions control the traversal of directories.
+type WalkOptions struct {
+	Ignores *Ignorer
+	// FollowSymlinks enters symlinked directories and finds targets of
+	// symlinked files, otherwise symlinks are skipped. Every directory is
+	// entered and every file is found once, so loops are not followed.
+	FollowSymlinks bool
+	// MaxDepth is the deepest level files are found at, files directly in the
+	// walked directory are at level 1. Zero means unlimited.
+	MaxDepth int
+	// OneFileSystem skips directories on other file systems than the walked
+	// directory.
+	OneFileSystem bool
+	// DedupeHardlinks finds files with multiple hard links only once.
+	DedupeHardlinks bool
+}
+
+// fileKey identifies a file regardless of its path.
+type fileKey struct {
+	dev uint64
+	ino uint64
+}
+
+// walker holds the state of a single Walk.
+type walker struct {
+	ctx  context.Context
+	opts WalkOptions
+	fn   func(path string, info os.FileInfo)
+	errs []error
+
+	rootDev uint64
+	hasDev  bool
+	dirs    map[any]bool
+	files   map[any]bool
+}
+
+// Walk calls fn for every media file in dir which is not ignored, ignored
+// directories are not entered. Paths which cannot be read are logged and
+// returned. It stops early when ctx is done.
+func Walk(ctx context.Context, dir string, opts WalkOptions, fn func(path string, info os.FileInfo)) []error {
+	w := &walker{ctx: ctx, opts: opts, fn: fn, dirs: map[any]bool{}, files: map[any]bool{}}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		w.fail(dir, err)
+		return w.errs
+	}
+	if key, ok := fileID(info); ok {
+		w.rootDev, w.hasDev = key.dev, true
+	}
+	w.dir(dir, info, 0)
+	return w.errs
+}
+
+func (w *walker) fail(path string, err error) {
+	slog.Warn("cannot read path", "path", path, "error", err)
+	w.errs = append(w.errs, err)
+}
+
+// dir walks entries of the directory at the given depth.
+func (w *walker) dir(dir string, info os.FileInfo, depth int) {
+	if w.visited(w.dirs, dir, info) {
+		slog.Debug("skipping directory entered already, possibly a symlink loop", "path", dir)
+		return
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		w.fail(dir, err)
+	}
+	for _, entry := range entries {
+		if w.ctx.Err() != nil {
+			return
+		}
+		w.entry(filepath.Join(dir, entry.Name()), depth+1)
+	}
+}
+
+// entry handles a single directory entry found at the given depth.
+func (w *walker) entry(path string, depth int) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		w.fail(path, err)
+		return
+	}
+
+	real := path
+	if info.Mode()&os.ModeSymlink != 0 {
+		if !w.opts.FollowSymlinks {
+			slog.Debug("skipping symlink", "path", path)
+			return
+		}
+		if info, err = os.Stat(path); err != nil {
+			w.fail(path, err)
+			return
+		}
+		// converted files replace the target, not the link
+		if !info.IsDir() {
+			if real, err = filepath.EvalSymlinks(path); err != nil {
+				w.fail(path, err)
+				return
+			}
+		}
+	}
+
+	if ignored, rule := w.opts.Ignores.Match(path, info.IsDir()); explainIgnore(path, ignored, rule) {
+		return
+	}
+
+	switch {
+	case info.IsDir():
+		if inBackupDir(path) {
+			slog.Debug("skipping backup directory", "path", path)
+			return
+		}
+		if w.opts.MaxDepth > 0 && depth >= w.opts.MaxDepth {
+			slog.Debug("skipping directory below max depth", "path", path)
+			return
+		}
+		if key, ok := fileID(info); w.opts.OneFileSystem && ok && w.hasDev && key.dev != w.rootDev {
+			slog.Debug("skipping directory on another file system", "path", path)
+			return
+		}
+		w.dir(path, info, depth)
+	case !info.Mode().IsRegular() || !isMedia(path):
+	case (w.opts.DedupeHardlinks || w.opts.FollowSymlinks) && w.visited(w.files, real, info):
+		slog.Debug("skipping file found already by another link", "path", path, "file", real)
+	default:
+		w.fn(real, info)
+	}
+}
+
+// visited marks the file as seen, reporting whether it was seen before. Files
+// are identified by their device and inode, or by their real path where these
+// are not available.
+func (w *walker) visited(seen map[any]bool, path string, info os.FileInfo) bool {
+	var key any
+	if id, ok := fileID(info); ok {
+		key = id
+	} else if real, err := filepath.EvalSymlinks(path); err == nil {
+		key = real
+	} else {
+		key = path
+	}
+	if seen[key] {
+		return true
+	}
+	seen[key] = true
+	return false
+}
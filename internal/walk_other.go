@@ -0,0 +1,12 @@
+//go:build !(linux || darwin || freebsd || openbsd || dragonfly)
+
+package internal
+
+import (
+	"os"
+)
+
+// fileID is not available, files are told apart by their paths.
+func fileID(info os.FileInfo) (fileKey, bool) {
+	return fileKey{}, false
+}
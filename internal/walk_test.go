@@ -0,0 +1,137 @@
+package internal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+)
+
+// walkTree creates files and directories, paths ending by a slash are
+// directories.
+func walkTree(t *testing.T, paths ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, p := range paths {
+		path := filepath.Join(dir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if p[len(p)-1] == '/' {
+			continue
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func collected(t *testing.T, dir string, opts WalkOptions) ([]string, []error) {
+	t.Helper()
+	files, errs := Collect(context.Background(), dir, opts)
+	var got []string
+	for _, f := range files {
+		rel, err := filepath.Rel(dir, f.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, filepath.ToSlash(rel))
+	}
+	return got, errs
+}
+
+func TestWalkSymlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges")
+	}
+	dir := walkTree(t, "a/x.mkv", "b/y.mkv")
+	// a loop back to the root and a second link to the same file
+	if err := os.Symlink("..", filepath.Join(dir, "a", "loop")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "b", "y.mkv"), filepath.Join(dir, "a", "z.mkv")); err != nil {
+		t.Fatal(err)
+	}
+
+	got, errs := collected(t, dir, WalkOptions{})
+	if want := []string{"a/x.mkv", "b/y.mkv"}; !slices.Equal(got, want) || len(errs) > 0 {
+		t.Errorf("without following got %v %v, want %v", got, errs, want)
+	}
+
+	got, errs = collected(t, dir, WalkOptions{FollowSymlinks: true})
+	if want := []string{"a/x.mkv", "b/y.mkv"}; !slices.Equal(got, want) || len(errs) > 0 {
+		t.Errorf("following got %v %v, want %v", got, errs, want)
+	}
+}
+
+func TestWalkMaxDepth(t *testing.T) {
+	dir := walkTree(t, "a.mkv", "s/b.mkv", "s/t/c.mkv")
+	tests := []struct {
+		depth int
+		want  []string
+	}{
+		{0, []string{"a.mkv", "s/b.mkv", "s/t/c.mkv"}},
+		{1, []string{"a.mkv"}},
+		{2, []string{"a.mkv", "s/b.mkv"}},
+	}
+	for _, tt := range tests {
+		if got, _ := collected(t, dir, WalkOptions{MaxDepth: tt.depth}); !slices.Equal(got, tt.want) {
+			t.Errorf("depth %d: got %v, want %v", tt.depth, got, tt.want)
+		}
+	}
+}
+
+func TestWalkHardlinks(t *testing.T) {
+	dir := walkTree(t, "a/x.mkv")
+	if err := os.MkdirAll(filepath.Join(dir, "b"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(dir, "a", "x.mkv"), filepath.Join(dir, "b", "x.mkv")); err != nil {
+		t.Skip(err)
+	}
+
+	if got, _ := collected(t, dir, WalkOptions{}); len(got) != 2 {
+		t.Errorf("got %v, want both links", got)
+	}
+	if got, _ := collected(t, dir, WalkOptions{DedupeHardlinks: true}); !slices.Equal(got, []string{"a/x.mkv"}) {
+		t.Errorf("got %v, want a single link", got)
+	}
+}
+
+func TestWalkUnreadable(t *testing.T) {
+	if _, errs := collected(t, filepath.Join(t.TempDir(), "missing"), WalkOptions{}); len(errs) != 1 {
+		t.Errorf("got errors %v, want the missing directory", errs)
+	}
+
+	if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+		t.Skip("permissions are not enforced")
+	}
+	dir := walkTree(t, "a.mkv", "locked/b.mkv")
+	locked := filepath.Join(dir, "locked")
+	if err := os.Chmod(locked, 0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chmod(locked, 0o755) })
+
+	got, errs := collected(t, dir, WalkOptions{})
+	if !slices.Equal(got, []string{"a.mkv"}) {
+		t.Errorf("got %v, want readable files", got)
+	}
+	if len(errs) != 1 {
+		t.Errorf("got errors %v, want the locked directory", errs)
+	}
+}
+
+func TestWalkSkipsBackupDir(t *testing.T) {
+	dir := walkTree(t, "a.mkv", "backup/lib/a.mkv")
+	backupDir := BackupDir
+	BackupDir = filepath.Join(dir, "backup")
+	t.Cleanup(func() { BackupDir = backupDir })
+
+	if got, _ := collected(t, dir, WalkOptions{}); !slices.Equal(got, []string{"a.mkv"}) {
+		t.Errorf("got %v, want backups left out", got)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build linux || darwin || freebsd || openbsd || dragonfly
+
+package internal
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileID returns the device and inode of the file.
+func fileID(info os.FileInfo) (fileKey, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileKey{}, false
+	}
+	return fileKey{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
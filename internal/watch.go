@@ -0,0 +1,213 @@
+package internal
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// WatchOptions configure Watch.
+type WatchOptions struct {
+	// StableFor is how long size and modification time of a file must stay the
+	// same before it is processed, so files being downloaded are not touched.
+	StableFor time.Duration
+	// Debounce is the quiet period after the last event of a file before it
+	// is checked, so bursts of writes are coalesced.
+	Debounce time.Duration
+	// PollInterval is used to scan the tree where file system events are not
+	// available.
+	PollInterval time.Duration
+	Ignores      *Ignorer
+	// Rescan checks the whole tree once the watch started, so files which
+	// arrived or changed before, e.g. during processing of the initial batch,
+	// are not missed. Known files are skipped unless they changed since they
+	// were collected.
+	Rescan bool
+	Known  []File
+}
+
+// pendingFile is a changed file waiting to become stable.
+type pendingFile struct {
+	event   time.Time
+	changed time.Time
+	size    int64
+	modTime time.Time
+}
+
+// fileStamp identifies a version of a file.
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// Watch calls fn with batches of media files in dir which were created or
+// changed, once they are stable. Files are never passed to fn concurrently.
+// It returns when ctx is done and the running batch is finished.
+func Watch(ctx context.Context, dir string, opts WatchOptions, fn func(files []File)) error {
+	events, err := watchTree(ctx, dir, opts.PollInterval)
+	if err != nil {
+		return err
+	}
+	slog.Info("watching for new files", "dir", dir, "stable_for", opts.StableFor)
+
+	tick := max(min(opts.StableFor, opts.Debounce, time.Second)/2, 10*time.Millisecond)
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	var (
+		pending   = make(map[string]*pendingFile)
+		processed = make(map[string]fileStamp)
+		ready     []File
+		running   = make(map[string]bool)
+		busy      bool
+		done      = make(chan []File)
+	)
+	if opts.Rescan {
+		for _, f := range opts.Known {
+			processed[f.Path] = fileStamp{size: f.Info.Size(), modTime: f.Info.ModTime()}
+		}
+		files, _ := Collect(ctx, dir, WalkOptions{Ignores: opts.Ignores})
+		for _, f := range files {
+			if processed[f.Path] != (fileStamp{size: f.Info.Size(), modTime: f.Info.ModTime()}) {
+				slog.Debug("file changed before watching", "path", f.Path)
+				pending[f.Path] = &pendingFile{event: time.Now(), size: -1}
+			}
+		}
+	}
+	for {
+		if !busy && len(ready) > 0 && ctx.Err() == nil {
+			batch := ready
+			ready = nil
+			busy = true
+			for _, f := range batch {
+				running[f.Path] = true
+			}
+			go func() {
+				fn(batch)
+				done <- batch
+			}()
+		}
+
+		select {
+		case <-ctx.Done():
+			if busy {
+				<-done
+			}
+			return nil
+		case batch := <-done:
+			busy = false
+			// conversions rewrite files, which must not trigger them again
+			for _, f := range batch {
+				delete(running, f.Path)
+				if info, err := os.Stat(f.Path); err == nil {
+					processed[f.Path] = fileStamp{size: info.Size(), modTime: info.ModTime()}
+				}
+			}
+		case path, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if _, leftover := LeftoverSource(path); leftover || !isMedia(path) || inBackupDir(path) {
+				continue
+			}
+			if ignored, rule := opts.Ignores.Ignored(path, false); explainIgnore(path, ignored, rule) {
+				continue
+			}
+			if p, ok := pending[path]; ok {
+				p.event = time.Now()
+			} else {
+				slog.Debug("file changed", "path", path)
+				pending[path] = &pendingFile{event: time.Now(), size: -1}
+			}
+		case now := <-ticker.C:
+			var stable []File
+			for path, p := range pending {
+				// changes by the running batch are checked once it is done
+				if running[path] || now.Sub(p.event) < opts.Debounce {
+					continue
+				}
+				info, err := os.Stat(path)
+				if err != nil {
+					delete(pending, path)
+					continue
+				}
+				if info.Size() != p.size || !info.ModTime().Equal(p.modTime) {
+					p.size, p.modTime, p.changed = info.Size(), info.ModTime(), now
+					continue
+				}
+				if now.Sub(p.changed) < opts.StableFor {
+					continue
+				}
+
+				delete(pending, path)
+				if processed[path] == (fileStamp{size: info.Size(), modTime: info.ModTime()}) {
+					continue
+				}
+				stable = append(stable, File{Path: path, Info: info})
+			}
+			sort.Slice(stable, func(i, j int) bool {
+				return stable[i].Path < stable[j].Path
+			})
+			ready = append(ready, stable...)
+		}
+	}
+}
+
+// pollTree sends paths of media files in dir which appeared or changed since
+// the previous scan.
+func pollTree(ctx context.Context, dir string, interval time.Duration, events chan<- string) {
+	defer close(events)
+
+	known := make(map[string]fileStamp)
+	scan := func(send bool) {
+		_ = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() || ctx.Err() != nil {
+				return nil
+			}
+			stamp := fileStamp{size: info.Size(), modTime: info.ModTime()}
+			if known[path] == stamp {
+				return nil
+			}
+			known[path] = stamp
+			if send {
+				select {
+				case events <- path:
+				case <-ctx.Done():
+				}
+			}
+			return nil
+		})
+	}
+
+	scan(false)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			scan(true)
+		}
+	}
+}
+
+// sendTree sends paths of all files in dir, e.g. of a directory which appeared
+// in the watched tree.
+func sendTree(ctx context.Context, dir string, events chan<- string) {
+	_ = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || ctx.Err() != nil {
+			return nil
+		}
+		if !info.IsDir() {
+			select {
+			case events <- path:
+			case <-ctx.Done():
+			}
+		}
+		return nil
+	})
+}
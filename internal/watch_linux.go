@@ -0,0 +1,177 @@
+package internal
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	inotifyDirMask  = syscall.IN_CREATE | syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DONT_FOLLOW
+	inotifyFileMask = syscall.IN_CREATE | syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO
+)
+
+// inotifyWatcher watches a directory tree using inotify, which needs a watch
+// for every directory.
+type inotifyWatcher struct {
+	fd   int
+	dirs map[int]string
+}
+
+// watchTree sends paths of files in dir which were created or changed. New
+// and renamed directories are watched as well and their files are sent. When
+// inotify cannot be used, the tree is polled.
+func watchTree(ctx context.Context, dir string, interval time.Duration) (<-chan string, error) {
+	events := make(chan string, 1024)
+
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		slog.Warn("cannot use inotify, polling for changes", "error", err)
+		go pollTree(ctx, dir, interval, events)
+		return events, nil
+	}
+
+	w := &inotifyWatcher{fd: fd, dirs: make(map[int]string)}
+	if err := w.addTree(dir); err != nil {
+		_ = syscall.Close(fd)
+		if errors.Is(err, syscall.ENOSPC) {
+			slog.Warn("too many directories for inotify, polling for changes", "error", err)
+			go pollTree(ctx, dir, interval, events)
+			return events, nil
+		}
+		return nil, err
+	}
+
+	// a non-blocking descriptor makes reads use the runtime poller, so they
+	// are interrupted by Close
+	f := os.NewFile(uintptr(fd), "inotify")
+	go func() {
+		<-ctx.Done()
+		_ = f.Close()
+	}()
+	go w.run(ctx, f, dir, events)
+	return events, nil
+}
+
+// addTree adds watches for dir and all its subdirectories.
+func (w *inotifyWatcher) addTree(dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			slog.Warn("cannot watch directory", "path", path, "error", err)
+			return nil
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		wd, err := syscall.InotifyAddWatch(w.fd, path, inotifyDirMask)
+		if err != nil {
+			return err
+		}
+		w.dirs[wd] = path
+		return nil
+	})
+}
+
+// renameTree updates paths of watched directories moved from old to to.
+func (w *inotifyWatcher) renameTree(old string, to string) {
+	for wd, path := range w.dirs {
+		if path == old || strings.HasPrefix(path, old+string(filepath.Separator)) {
+			w.dirs[wd] = to + strings.TrimPrefix(path, old)
+		}
+	}
+}
+
+// removeTree drops watches of a directory moved out of the watched tree.
+func (w *inotifyWatcher) removeTree(dir string) {
+	for wd, path := range w.dirs {
+		if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			_, _ = syscall.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.dirs, wd)
+		}
+	}
+}
+
+func (w *inotifyWatcher) run(ctx context.Context, f *os.File, root string, events chan<- string) {
+	defer close(events)
+
+	send := func(path string) {
+		select {
+		case events <- path:
+		case <-ctx.Done():
+		}
+	}
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := f.Read(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Error("cannot read file system events", "error", err)
+			}
+			return
+		}
+
+		// directories moved away, unless moved within the tree
+		movedFrom := make(map[uint32]string)
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			wd := int(int32(binary.NativeEndian.Uint32(buf[off:])))
+			mask := binary.NativeEndian.Uint32(buf[off+4:])
+			cookie := binary.NativeEndian.Uint32(buf[off+8:])
+			nameLen := int(binary.NativeEndian.Uint32(buf[off+12:]))
+			name := strings.TrimRight(string(buf[off+syscall.SizeofInotifyEvent:off+syscall.SizeofInotifyEvent+nameLen]), "\x00")
+			off += syscall.SizeofInotifyEvent + nameLen
+
+			if mask&syscall.IN_Q_OVERFLOW != 0 {
+				slog.Warn("file system events lost, rescanning", "dir", root)
+				sendTree(ctx, root, events)
+				continue
+			}
+			if mask&syscall.IN_IGNORED != 0 {
+				delete(w.dirs, wd)
+				continue
+			}
+			dir, ok := w.dirs[wd]
+			if !ok || name == "" {
+				continue
+			}
+			path := filepath.Join(dir, name)
+
+			if mask&syscall.IN_ISDIR == 0 {
+				if mask&inotifyFileMask != 0 {
+					send(path)
+				}
+				continue
+			}
+
+			switch {
+			case mask&syscall.IN_MOVED_FROM != 0:
+				movedFrom[cookie] = path
+			case mask&syscall.IN_MOVED_TO != 0:
+				if old, ok := movedFrom[cookie]; ok {
+					delete(movedFrom, cookie)
+					slog.Debug("directory renamed", "from", old, "to", path)
+					w.renameTree(old, path)
+				} else if err := w.addTree(path); err != nil {
+					slog.Warn("cannot watch directory", "path", path, "error", err)
+				}
+				sendTree(ctx, path, events)
+			case mask&syscall.IN_CREATE != 0:
+				if err := w.addTree(path); err != nil {
+					slog.Warn("cannot watch directory", "path", path, "error", err)
+				}
+				sendTree(ctx, path, events)
+			}
+		}
+
+		for _, dir := range movedFrom {
+			slog.Debug("directory moved away", "path", dir)
+			w.removeTree(dir)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package internal
+
+import (
+	"context"
+	"time"
+)
+
+// watchTree polls dir for changes, file system events are supported on Linux
+// only.
+func watchTree(ctx context.Context, dir string, interval time.Duration) (<-chan string, error) {
+	events := make(chan string, 1024)
+	go pollTree(ctx, dir, interval, events)
+	return events, nil
+}
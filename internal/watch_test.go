@@ -0,0 +1,104 @@
+package internal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batches := make(chan []File, 10)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- Watch(ctx, dir, WatchOptions{StableFor: 100 * time.Millisecond, Debounce: 20 * time.Millisecond, PollInterval: 50 * time.Millisecond}, func(files []File) {
+			batches <- files
+		})
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	expect := func(path string) {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case files := <-batches:
+				for _, f := range files {
+					if f.Path == path {
+						return
+					}
+				}
+			case <-timeout:
+				t.Fatalf("%s not processed", path)
+			}
+		}
+	}
+	write := func(path string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(path), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(filepath.Join(dir, "sub", "a.mkv"))
+	write(filepath.Join(dir, "sub", "notes.txt"))
+	expect(filepath.Join(dir, "sub", "a.mkv"))
+
+	// files in renamed and new directories
+	if err := os.Rename(filepath.Join(dir, "sub"), filepath.Join(dir, "renamed")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	write(filepath.Join(dir, "renamed", "b.mkv"))
+	expect(filepath.Join(dir, "renamed", "b.mkv"))
+
+	if err := os.MkdirAll(filepath.Join(dir, "new", "deep"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	write(filepath.Join(dir, "new", "deep", "c.mp4"))
+	expect(filepath.Join(dir, "new", "deep", "c.mp4"))
+
+	cancel()
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatchRescan(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"known.mkv", "new.mkv"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	known, err := StatFiles(filepath.Join(dir, "known.mkv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batches := make(chan []File, 10)
+	go func() {
+		_ = Watch(ctx, dir, WatchOptions{StableFor: 50 * time.Millisecond, Debounce: 10 * time.Millisecond, PollInterval: time.Hour, Rescan: true, Known: known}, func(files []File) {
+			batches <- files
+		})
+	}()
+
+	select {
+	case files := <-batches:
+		if len(files) != 1 || filepath.Base(files[0].Path) != "new.mkv" {
+			t.Errorf("got batch %v, want new.mkv only", files)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("files changed before watching not processed")
+	}
+}
@@ -0,0 +1,201 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"text/template"
+	"time"
+)
+
+// Webhook events.
+const (
+	EventFileFinished  = "file_finished"
+	EventFileFailed    = "file_failed"
+	EventBatchFinished = "batch_finished"
+)
+
+// WebhookEvent is the payload posted to webhooks, or the data of their
+// template.
+type WebhookEvent struct {
+	Event   string       `json:"event"`
+	Time    time.Time    `json:"time"`
+	Command string       `json:"command"`
+	File    *ReportEntry `json:"file,omitempty"`
+	Summary *Summary     `json:"summary,omitempty"`
+}
+
+// webhookQueueSize is the number of events waiting for delivery, more events
+// are dropped so a slow endpoint does not hold up processing.
+const webhookQueueSize = 100
+
+// Webhook posts events to URLs in the background, in the order they happened.
+// Failed deliveries are retried with a doubling delay.
+type Webhook struct {
+	URLs []string
+	// Template renders the body, the event is posted as JSON when it is nil.
+	Template    *template.Template
+	ContentType string
+	Timeout     time.Duration
+	Retries     int
+	RetryDelay  time.Duration
+	// CloseTimeout is how long Close waits for pending events, then their
+	// delivery is cancelled. Zero means no limit.
+	CloseTimeout time.Duration
+
+	client *http.Client
+	events chan WebhookEvent
+	done   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Start starts delivering events, Close must be called to deliver the pending
+// ones.
+func (w *Webhook) Start() {
+	w.client = &http.Client{Timeout: w.Timeout}
+	w.events = make(chan WebhookEvent, webhookQueueSize)
+	w.done = make(chan struct{})
+	w.ctx, w.cancel = context.WithCancel(context.Background())
+	go func() {
+		defer close(w.done)
+		dropped := 0
+		for ev := range w.events {
+			if w.ctx.Err() != nil {
+				dropped++
+				continue
+			}
+			w.deliver(ev)
+		}
+		if dropped > 0 {
+			slog.Warn("webhooks not delivered in time", "events", dropped)
+		}
+	}()
+}
+
+// Close waits until all events are delivered or given up, at most for
+// CloseTimeout.
+func (w *Webhook) Close() {
+	defer w.cancel()
+	close(w.events)
+	if w.CloseTimeout <= 0 {
+		<-w.done
+		return
+	}
+	timer := time.NewTimer(w.CloseTimeout)
+	defer timer.Stop()
+	select {
+	case <-w.done:
+	case <-timer.C:
+		w.cancel()
+		<-w.done
+	}
+}
+
+// send queues the event, dropping it when the queue is full.
+func (w *Webhook) send(ev WebhookEvent) {
+	select {
+	case w.events <- ev:
+	default:
+		slog.Warn("too many pending webhooks, event dropped", "event", ev.Event)
+	}
+}
+
+// FileFinished posts the outcome of a file described by its report entry.
+func (w *Webhook) FileFinished(e *ReportEntry) {
+	ev := WebhookEvent{Event: EventFileFinished, Time: time.Now(), Command: e.Command, File: e}
+	if e.Error != "" {
+		ev.Event = EventFileFailed
+	}
+	w.send(ev)
+}
+
+// BatchFinished posts summary counts of a finished batch.
+func (w *Webhook) BatchFinished(command string, s Summary) {
+	w.send(WebhookEvent{Event: EventBatchFinished, Time: time.Now(), Command: command, Summary: &s})
+}
+
+func (w *Webhook) deliver(ev WebhookEvent) {
+	var body bytes.Buffer
+	var err error
+	if w.Template != nil {
+		err = w.Template.Execute(&body, ev)
+	} else {
+		err = json.NewEncoder(&body).Encode(ev)
+	}
+	if err != nil {
+		slog.Error("cannot render webhook", "event", ev.Event, "error", err)
+		return
+	}
+
+	for _, url := range w.URLs {
+		delay := w.RetryDelay
+		for attempt := 0; ; attempt++ {
+			retry, err := w.post(w.ctx, url, body.Bytes())
+			if err == nil {
+				slog.Debug("webhook delivered", "url", url, "event", ev.Event)
+				break
+			}
+			if !retry || attempt >= w.Retries {
+				slog.Warn("cannot deliver webhook", "url", url, "event", ev.Event, "error", err)
+				break
+			}
+			slog.Debug("retrying webhook", "url", url, "event", ev.Event, "error", err, "delay", delay)
+			select {
+			case <-time.After(delay):
+			case <-w.ctx.Done():
+				slog.Warn("cannot deliver webhook", "url", url, "event", ev.Event, "error", err)
+				return
+			}
+			delay *= 2
+		}
+	}
+}
+
+// post sends the body and reports whether a failure is worth retrying.
+func (w *Webhook) post(ctx context.Context, url string, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	contentType := w.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "mediatool")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return false, nil
+}
+
+// ParseWebhookTemplate reads a text/template rendering webhook bodies from a
+// WebhookEvent. Besides the builtins, it provides json (a JSON encoded
+// value), base (the file name of a path) and size (a human readable size).
+func ParseWebhookTemplate(path string) (*template.Template, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return template.New(filepath.Base(path)).Funcs(template.FuncMap{
+		"json": func(v any) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+		"base": filepath.Base,
+		"size": FormatSize,
+	}).Parse(string(b))
+}
@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhook(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		bodies   []string
+		attempts int
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, r.Header.Get("Content-Type")+" "+string(b))
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	tmpl := filepath.Join(dir, "body.tmpl")
+	err := os.WriteFile(tmpl, []byte(`{"text": {{with .File}}{{json (printf "%s %s" $.Event (base .File))}}{{else}}{{json .Event}}{{end}}{{with .Summary}}, "failed": {{.Failed}}{{end}}}`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhook := &Webhook{URLs: []string{ts.URL}, ContentType: "text/plain", Timeout: time.Second, Retries: 1, RetryDelay: time.Millisecond}
+	if webhook.Template, err = ParseWebhookTemplate(tmpl); err != nil {
+		t.Fatal(err)
+	}
+	webhook.Start()
+
+	var files []File
+	for _, name := range []string{"a.mkv", "b.mkv"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		info, _ := os.Stat(path)
+		files = append(files, File{Path: path, Info: info})
+	}
+	runner := &Runner{Jobs: 1, Command: "test", Webhook: webhook}
+	summary := runner.Run(context.Background(), context.Background(), files, func(ctx context.Context, path string) error {
+		if filepath.Base(path) == "b.mkv" {
+			return errors.New("broken")
+		}
+		return nil
+	})
+	webhook.BatchFinished("test", summary)
+	webhook.Close()
+
+	want := []string{
+		`text/plain {"text": "file_finished a.mkv"}`,
+		`text/plain {"text": "file_failed b.mkv"}`,
+		`text/plain {"text": "batch_finished", "failed": 1}`,
+	}
+	if strings.Join(bodies, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got bodies:\n%s\nwant:\n%s", strings.Join(bodies, "\n"), strings.Join(want, "\n"))
+	}
+	if attempts != 4 {
+		t.Errorf("got %d attempts, want 4", attempts)
+	}
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	attempts := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	webhook := &Webhook{URLs: []string{ts.URL}, Timeout: time.Second, Retries: 3, RetryDelay: time.Millisecond}
+	webhook.Start()
+	webhook.BatchFinished("test", Summary{Total: 1})
+	webhook.Close()
+
+	// client errors are not retried
+	if attempts != 1 {
+		t.Errorf("got %d attempts, want 1", attempts)
+	}
+}
+
+func TestWebhookDown(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	webhook := &Webhook{URLs: []string{ts.URL}, Timeout: time.Second, Retries: 3, RetryDelay: time.Hour, CloseTimeout: 50 * time.Millisecond}
+	webhook.Start()
+
+	// events over the queue size are dropped instead of blocking
+	start := time.Now()
+	for range 2 * webhookQueueSize {
+		webhook.BatchFinished("test", Summary{Total: 1})
+	}
+	webhook.Close()
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("sending and closing took %v", elapsed)
+	}
+}
@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// copyXattrs copies extended attributes of src to dst.
+func copyXattrs(src string, dst string) (warnings []string) {
+	names, err := listXattrs(src)
+	if err != nil {
+		return []string{fmt.Sprintf("cannot list extended attributes: %v", err)}
+	}
+
+	for _, name := range names {
+		value, err := getXattr(src, name)
+		if err == nil {
+			err = syscall.Setxattr(dst, name, value, 0)
+		}
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("cannot copy extended attribute %s: %v", name, err))
+		}
+	}
+	return warnings
+}
+
+func listXattrs(path string) ([]string, error) {
+	size, err := syscall.Listxattr(path, nil)
+	if err != nil || size == 0 {
+		if err == syscall.ENOTSUP {
+			err = nil
+		}
+		return nil, err
+	}
+	buf := make([]byte, size)
+	size, err = syscall.Listxattr(path, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, name := range bytes.Split(buf[:size], []byte{0}) {
+		if len(name) > 0 {
+			names = append(names, string(name))
+		}
+	}
+	return names, nil
+}
+
+func getXattr(path string, name string) ([]byte, error) {
+	size, err := syscall.Getxattr(path, name, nil)
+	if err != nil || size == 0 {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	size, err = syscall.Getxattr(path, name, buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:size], nil
+}
+
+// accessTime returns the last access time of the file.
+func accessTime(info os.FileInfo) time.Time {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(st.Atim.Unix())
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,28 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestCopyXattrs(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+	for _, path := range []string{src, dst} {
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := syscall.Setxattr(src, "user.mediatool", []byte("value"), 0); err != nil {
+		t.Skipf("extended attributes not supported: %v", err)
+	}
+
+	if warnings := copyXattrs(src, dst); len(warnings) > 0 {
+		t.Fatal(warnings)
+	}
+	if value, err := getXattr(dst, "user.mediatool"); err != nil || string(value) != "value" {
+		t.Errorf("unexpected attribute: %q, %v", value, err)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package internal
+
+import (
+	"os"
+	"time"
+)
+
+// copyXattrs is supported on Linux only.
+func copyXattrs(_ string, _ string) []string {
+	return nil
+}
+
+// accessTime falls back to the modification time where the access time is not
+// portably available.
+func accessTime(info os.FileInfo) time.Time {
+	return info.ModTime()
+}